@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"mainProject/logging"
+	"mainProject/metrics"
+	"mainProject/shutdown"
+	"mainProject/singleElevator"
+	"net"
+	"net/http"
+)
+
+// -----------------------------------------------------------------------------
+// HTTP status and control API
+// -----------------------------------------------------------------------------
+// Lets operators and test scripts observe a running elevator and drive it remotely.
+// Calls posted here are injected as button presses, so they follow the same path
+// as a press on the physical panel.
+//
+//	GET  /elevator    Local elevator state
+//	GET  /statuses    Statuses of all known elevators
+//	GET  /master      Current master
+//	GET  /acks        Sequence numbers of messages waiting for an ack
+//	GET  /peers       Elevators currently seen on the network
+//	GET  /metrics     Metrics in the Prometheus text format
+//	POST /calls/cab   {"Floor": 2}
+//	POST /calls/hall  {"Floor": 2, "Direction": "up"}
+//	POST /admin/shutdown  Hands over and exits, as on SIGTERM. Only from this host.
+
+var log = logging.For("api")
+
+type masterResponse struct {
+	MasterID   string
+	MasterTerm int
+	LocalID    string
+	IsMaster   bool
+}
+
+type acksResponse struct {
+	Pending []int
+}
+
+type peersResponse struct {
+	Peers []string
+}
+
+type cabCallRequest struct {
+	Floor int
+}
+
+type hallCallRequest struct {
+	Floor     int
+	Direction string // "up" or "down"
+}
+
+// Serves the API on `addr` until the listener fails. An empty address disables the API.
+func RunAPI(addr string) {
+	if addr == "" {
+		return
+	}
+	log.Info("HTTP API listening", "addr", addr)
+	if err := http.ListenAndServe(addr, NewHandler()); err != nil {
+		// The elevator keeps running without the API
+		log.Error("HTTP API stopped", "err", err)
+	}
+}
+
+func NewHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /elevator", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, singleElevator.GetElevatorState())
+	})
+	mux.HandleFunc("GET /statuses", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, communication.GetElevatorStatuses())
+	})
+	mux.HandleFunc("GET /master", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, masterResponse{
+			MasterID:   config.MasterID(),
+			MasterTerm: config.MasterTerm(),
+			LocalID:    config.LocalID,
+			IsMaster:   config.MasterID() != "" && config.MasterID() == config.LocalID,
+		})
+	})
+	mux.HandleFunc("GET /acks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, acksResponse{Pending: communication.GetPendingAcks()})
+	})
+	mux.HandleFunc("GET /peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, peersResponse{Peers: communication.GetPeers()})
+	})
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("POST /calls/cab", handleCabCall)
+	mux.HandleFunc("POST /calls/hall", handleHallCall)
+	mux.HandleFunc("POST /admin/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		// The API may listen on the network for the status endpoints, but only an
+		// operator on the elevator's own computer may stop it
+		if !fromLoopback(r) {
+			log.Warn("Refused shutdown request from another host", "remote", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, errors.New("shutdown is only accepted from this host"))
+			return
+		}
+		log.Info("Shutdown requested through the HTTP API", "remote", r.RemoteAddr)
+		shutdown.Request("http")
+		writeJSON(w, http.StatusAccepted, map[string]string{"Status": "shutting down"})
+	})
+	return mux
+}
+
+func handleCabCall(w http.ResponseWriter, r *http.Request) {
+	var req cabCallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	injectCall(w, elevio.ButtonEvent{Floor: req.Floor, Button: elevio.BT_Cab})
+}
+
+func handleHallCall(w http.ResponseWriter, r *http.Request) {
+	var req hallCallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	var button elevio.ButtonType
+	switch req.Direction {
+	case "up":
+		button = elevio.BT_HallUp
+	case "down":
+		button = elevio.BT_HallDown
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("direction must be \"up\" or \"down\", got %q", req.Direction))
+		return
+	}
+	injectCall(w, elevio.ButtonEvent{Floor: req.Floor, Button: button})
+}
+
+func injectCall(w http.ResponseWriter, event elevio.ButtonEvent) {
+	if !config.ButtonExists(event.Floor, event.Button) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("there is no such button at floor %d", event.Floor))
+		return
+	}
+	if err := singleElevator.InjectButtonPress(event); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	log.Info("Call injected through the HTTP API", "floor", event.Floor, "button", event.Button)
+	writeJSON(w, http.StatusAccepted, event)
+}
+
+// Whether the request comes from this host
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"Error": err.Error()})
+}
@@ -0,0 +1,37 @@
+package clock
+
+import "time"
+
+// Clock abstracts the parts of the time package used by the elevator logic,
+// so timers and sleeps can run on virtual time in tests.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// Timer mirrors time.Timer, with the channel behind a method so it can be faked
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the wall clock, backed by the time package
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r realTimer) C() <-chan time.Time        { return r.t.C }
+func (r realTimer) Stop() bool                 { return r.t.Stop() }
+func (r realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
@@ -0,0 +1,125 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Virtual is a manually advanced clock. Time only moves when Advance,
+// AdvanceToNext or Sleep is called, and timers fire in deadline order.
+type Virtual struct {
+	mtx    sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+type virtualTimer struct {
+	clock    *Virtual
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	return v.now
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	t := &virtualTimer{clock: v, c: make(chan time.Time, 1), deadline: v.now.Add(d), active: true}
+	v.timers = append(v.timers, t)
+	v.fireDue()
+	return t
+}
+
+// Sleeping on a virtual clock advances it, as nothing else would
+func (v *Virtual) Sleep(d time.Duration) {
+	v.Advance(d)
+}
+
+// Moves time forward by `d`, firing every timer that expires on the way
+func (v *Virtual) Advance(d time.Duration) {
+	v.mtx.Lock()
+	target := v.now.Add(d)
+	v.mtx.Unlock()
+	for v.AdvanceToNext(target) {
+	}
+}
+
+// Moves time forward to the earliest timer deadline, if it is no later than `limit`,
+// and fires that timer. Otherwise moves time to `limit` and returns false.
+// Lets callers react to each timer before later ones are considered.
+func (v *Virtual) AdvanceToNext(limit time.Time) bool {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	var next *virtualTimer
+	for _, t := range v.timers {
+		if t.active && (next == nil || t.deadline.Before(next.deadline)) {
+			next = t
+		}
+	}
+	if next == nil || next.deadline.After(limit) {
+		if limit.After(v.now) {
+			v.now = limit
+		}
+		return false
+	}
+	if next.deadline.After(v.now) {
+		v.now = next.deadline
+	}
+	v.fireDue()
+	return true
+}
+
+// Must be called with the mutex held
+func (v *Virtual) fireDue() {
+	for _, t := range v.timers {
+		if t.active && !t.deadline.After(v.now) {
+			t.active = false
+			select {
+			case t.c <- v.now:
+			default:
+			}
+		}
+	}
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Like time.Timer (Go 1.23+), a stopped or reset timer never delivers a stale value
+func (t *virtualTimer) Stop() bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.drain()
+	return wasActive
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	wasActive := t.active
+	t.drain()
+	t.deadline = t.clock.now.Add(d)
+	t.active = true
+	t.clock.fireDue()
+	return wasActive
+}
+
+func (t *virtualTimer) drain() {
+	select {
+	case <-t.c:
+	default:
+	}
+}
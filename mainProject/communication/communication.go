@@ -3,22 +3,13 @@ package communication
 import (
 	"mainProject/config"
 	"mainProject/elevio"
+	"mainProject/logging"
 	"mainProject/network/bcast"
 	"mainProject/network/peers"
 	"sync"
 	"time"
 )
 
-const (
-	broadcastPort     = 30000 // Port for broadcasting elevator states
-	peerPort          = 30001 // Port for receiving elevator state updates
-	assignmentPort    = 30002 // Port for broadcasting assigned hall calls
-	txrawHallCallPort = 30003 // Port for raw hall calls (hall calls received by slaves, that needs to be forwarded to the master before assigning them)
-	ackPort			  = 30004 // Port for reading the masters ack for hall calls from slaves
-	statusPort        = 30005 // Port for hall call confirmations
-	lightPort         = 30006 // Port for hall call light orders
-)
-
 // -----------------------------------------------------------------------------
 // Data Structures
 // -----------------------------------------------------------------------------
@@ -27,8 +18,15 @@ type ElevatorStatus struct {
 	Floor     int
 	State config.ElevatorState
 	Direction elevio.MotorDirection
-	Queue     [config.NumFloors][config.NumButtons]bool
+	Queue     config.Queue
+	Available bool // False while the elevator cannot take hall calls, e.g. during an emergency stop
 	Timestamp time.Time
+	MasterID   string // The master this elevator follows, used to detect a split brain
+	MasterTerm int
+	Obstructed bool      // Door obstructed, so the elevator is a poor choice for master
+	StartedAt  time.Time // When the elevator process started, for the uptime election rule
+	Priority   int       // Static election priority from the configuration
+	HallOrders HallOrders // This elevator's view of the hall calls, see hallOrders.go
 }
 
 type AssignmentMessage struct {
@@ -36,6 +34,11 @@ type AssignmentMessage struct {
 	Floor    int
 	Button   elevio.ButtonType
 	SeqNum   int 
+	PressedAt time.Time // When the hall button was pressed, for service time metrics
+	OrderID   string    // Correlation ID of the hall call, for logging
+	Term      int       // Election term of the master that sent it
+	Revoke    bool      // Take the hall call out of the target's queue, as another elevator serves it
+	Delivery  Delivery  // Names the message for acks and duplicate filtering, see reliable.go
 }
 
 type RawHallCallMessage struct {
@@ -44,11 +47,16 @@ type RawHallCallMessage struct {
     Floor    int
     Button   elevio.ButtonType
 	SeqNum   int 
+	PressedAt time.Time
+	OrderID   string
+	Delivery  Delivery
 }
 
 type AckMessage struct {
 	TargetID string
 	SeqNum 	 int
+	Term     int // Election term known to the sender
+	Delivery Delivery // The message acked
 }
 
 type OrderStatus int
@@ -58,83 +66,138 @@ const (
 	Finished                 = 1
 )
 
+func (s OrderStatus) String() string {
+	if s == Finished {
+		return "finished"
+	}
+	return "unfinished"
+}
+
 type OrderStatusMessage struct {
     SenderID    string
     ButtonEvent elevio.ButtonEvent
 	Status      OrderStatus
 	SeqNum      int
+	OrderID     string
+	Delivery    Delivery
 }
 
-type LightStatus int
+type ElectionMessageType int
 
 const (
-	Off   LightStatus = 0
-	On                = 1
+	Announce ElectionMessageType = iota // A candidate asks to become master, or the master repeats that it is
+	Accept                              // The sender follows the announced master
+	Reject                              // The sender knows a newer term, given with its master
+	Handoff                             // The master asks the target to run for master, as it is shutting down
+	PreVote                             // An elevator without a master asks whether it may run for the given term
+	PreVoteGranted                      // The sender has no master and would accept the asked term
 )
 
-type LightOrderMessage struct {
-	TargetID    string
-	ButtonEvent elevio.ButtonEvent
-	Light       LightStatus
-	SeqNum  	int
+func (t ElectionMessageType) String() string {
+	switch t {
+	case Announce:
+		return "announce"
+	case Accept:
+		return "accept"
+	case Reject:
+		return "reject"
+	case Handoff:
+		return "handoff"
+	case PreVote:
+		return "pre_vote"
+	case PreVoteGranted:
+		return "pre_vote_granted"
+	}
+	return "unknown"
+}
+
+type ElectionMessage struct {
+	Type     ElectionMessageType
+	Term     int
+	SenderID string
+	MasterID string // The announced candidate, the accepted master or the master of the newer term
+	TargetID string // Empty for announcements
+}
+
+// Knowledge a master hands to its successor before a planned shutdown, one elevator per message
+type HandoffMessage struct {
+	TargetID string
+	SenderID string
+	SeqNum   int
+	Term     int            // Term of the sender, echoed in the ack
+	Status   ElevatorStatus // Last known status, used to restore the elevator's cab calls when it returns
+	Delivery Delivery
+}
+
+// Wire names of the messages, part of the protocol (see network/bcast/protocol.go).
+// They must not change when the Go types are renamed.
+func init() {
+	bcast.Register("elevator_status", ElevatorStatus{})
+	bcast.Register("assignment", AssignmentMessage{})
+	bcast.Register("raw_hall_call", RawHallCallMessage{})
+	bcast.Register("ack", AckMessage{})
+	bcast.Register("order_status", OrderStatusMessage{})
+	bcast.Register("election", ElectionMessage{})
+	bcast.Register("handoff", HandoffMessage{})
 }
 
 // -----------------------------------------------------------------------------
 // Global Variables
 // -----------------------------------------------------------------------------
+var log = logging.For("communication")
+
 var (
 	elevatorStatuses        = make(map[string]ElevatorStatus) // Global map to track all known elevators
 	backupElevatorStatuses  = make(map[string]ElevatorStatus)
+	mismatchedPeers         = make(map[string]bool) // Peers whose floor count differs from ours, logged once
+	currentPeers            []string
 	
 	txElevatorStatusChan    = make(chan ElevatorStatus, 50)
 	rxElevatorStatusChan    = make(chan ElevatorStatus, 50)
 	txAssignmentChan        = make(chan AssignmentMessage, 100)
 	txRawHallCallChan       = make(chan RawHallCallMessage, 100)
-	txLightChan	            = make(chan LightOrderMessage, 50)
+	txHandoffChan           = make(chan HandoffMessage, 50)
+	rxHandoffChan           = make(chan HandoffMessage, 50)
 	rxOrderStatusChan       = make(chan OrderStatusMessage, 100)
 	txOrderStatusChan       = make(chan OrderStatusMessage, 100)
 	rxAckChan				= make(chan AckMessage, 500)
-	
-	seqNumAssignmentCounter = 0
-	seqNumRawCallCounter	= 100
-	SeqOrderStatusCounter   = 200
-	seqLightCounter         = 300
 
 	stateMutex	              sync.Mutex
-	pendingAcks   		    = make(map[int]chan struct{})
-    pendingAcksMutex 		  sync.Mutex
 )
 
 // -----------------------------------------------------------------------------
 // Initialization and Network Management
 // -----------------------------------------------------------------------------
 func RunCommunication(elevatorStateChan chan map[string]ElevatorStatus, peerUpdates chan peers.PeerUpdate, orderStatusChan chan OrderStatusMessage, txAckChan chan AckMessage, localStatusUpdateChan chan config.Elevator) {
+	ports := config.Cfg.Network
+
 	// Start peer reciver to get updates from other elevators
-	go peers.Receiver(peerPort, peerUpdates)
+	go peers.Receiver(ports.PeerPort, peerUpdates)
 
 	// Periodically send updated elevator status to other modules (locally)
 	startPeriodicLocalStatusUpdates(elevatorStateChan)
 
 	// Start broadcasting and receiving elevator status
-	go bcast.Transmitter(broadcastPort, txElevatorStatusChan)
-	go bcast.Receiver(broadcastPort, rxElevatorStatusChan)
+	go bcast.Transmitter(ports.BroadcastPort, txElevatorStatusChan)
+	go bcast.Receiver(ports.BroadcastPort, rxElevatorStatusChan)
 
 	// Start broadcasting assignments
-	go bcast.Transmitter(assignmentPort, txAssignmentChan)
+	go bcast.Transmitter(ports.AssignmentPort, txAssignmentChan)
 
 	// Start broadcasting raw hall calls
-	go bcast.Transmitter(txrawHallCallPort, txRawHallCallChan)
+	go bcast.Transmitter(ports.RawHallCallPort, txRawHallCallChan)
 
 	// Start receiving and transmitting acks
-	go bcast.Receiver(ackPort, rxAckChan)
-	go bcast.Transmitter(ackPort, txAckChan)
+	go bcast.Receiver(ports.AckPort, rxAckChan)
+	go bcast.Transmitter(ports.AckPort, txAckChan)
 	
 	// Start receiving and transmitting order status
-	go bcast.Transmitter(statusPort, txOrderStatusChan)	
-	go bcast.Receiver(statusPort, rxOrderStatusChan)
+	go bcast.Transmitter(ports.StatusPort, txOrderStatusChan)	
+	go bcast.Receiver(ports.StatusPort, rxOrderStatusChan)
 
-	// Start broadcasting light orders
-	go bcast.Transmitter(lightPort, txLightChan)
+	// Start receiving and transmitting handoffs from a master shutting down. They share the election port.
+	go bcast.Transmitter(ports.ElectionPort, txHandoffChan)
+	go bcast.Receiver(ports.ElectionPort, rxHandoffChan)
 
 	go func() {
 		for {
@@ -143,20 +206,46 @@ func RunCommunication(elevatorStateChan chan map[string]ElevatorStatus, peerUpda
 				BroadcastElevatorStatus(newState, true)
 
 			case ack := <- rxAckChan:
-				pendingAcksMutex.Lock()
-				if ackChan, exists := pendingAcks[ack.SeqNum]; exists {
-					close(ackChan)
-					delete(pendingAcks, ack.SeqNum)
-				} 
-				pendingAcksMutex.Unlock()
+				handleAck(ack)
 
 			case orderStatus := <-rxOrderStatusChan:
 				orderStatusChan <- orderStatus
+
+			case handoff := <-rxHandoffChan:
+				if handoff.TargetID != config.LocalID {
+					continue
+				}
+				receiveHandoff(handoff, txAckChan)
 			
 			case hallAssignment := <-rxElevatorStatusChan:
+				// A peer configured for another building would corrupt order assignment
+				if len(hallAssignment.Queue) != config.NumFloors {
+					if !mismatchedPeers[hallAssignment.ID] {
+						log.Warn("Rejecting status from a peer with another floor count", "peer", hallAssignment.ID, "floors", len(hallAssignment.Queue), "expected", config.NumFloors)
+						mismatchedPeers[hallAssignment.ID] = true
+					}
+					stateMutex.Lock()
+					delete(elevatorStatuses, hallAssignment.ID)
+					stateMutex.Unlock()
+					continue
+				}
+				delete(mismatchedPeers, hallAssignment.ID)
 				stateMutex.Lock()
+				if known, exists := elevatorStatuses[hallAssignment.ID]; exists && len(known.Queue) == config.NumFloors && known.Timestamp.After(hallAssignment.Timestamp) {
+					stateMutex.Unlock()
+					continue // A late copy of an older status, which could take the hall order states back
+				}
 				elevatorStatuses[hallAssignment.ID] = hallAssignment
+				changed := false
+				if hallAssignment.ID != config.LocalID {
+					changed = mergeHallOrders(hallAssignment.ID, hallAssignment.HallOrders)
+				}
+				changed = advanceHallOrders() || changed
 				stateMutex.Unlock()
+				if changed {
+					go broadcastHallOrders()
+					notifyStatusesChanged() // Lets order assignment act on the change at once
+				}
 			}
 		}
 	}()	
@@ -0,0 +1,244 @@
+package communication
+
+import (
+	"mainProject/config"
+	"mainProject/elevio"
+	"mainProject/journal"
+	"mainProject/logging"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Replicated hall order states
+// -----------------------------------------------------------------------------
+// Every hall button has a state that goes round a cycle:
+//
+//	NoOrder -> Unconfirmed -> Confirmed -> Completing -> NoOrder
+//
+// Each elevator broadcasts its states in its status and adopts the states of its
+// peers that are ahead of its own. A press makes a call Unconfirmed. It becomes
+// Confirmed, and its lamp is lit, only once every alive elevator has it as
+// Unconfirmed or Confirmed, so a lit lamp is known to every elevator that could
+// serve it, even if packets are lost. Serving the call makes it Completing, and
+// it returns to NoOrder once every alive elevator has seen that.
+//
+// A press while the call is Completing is ignored, as an elevator is at the floor.
+
+type HallOrderState int
+
+const (
+	NoOrder     HallOrderState = iota
+	Unconfirmed                // Pressed, waiting for every alive elevator to see it
+	Confirmed                  // Seen by every alive elevator. The lamp is lit and an elevator will come
+	Completing                 // Served, waiting for every alive elevator to see it
+)
+
+func (s HallOrderState) String() string {
+	switch s {
+	case NoOrder:
+		return "none"
+	case Unconfirmed:
+		return "unconfirmed"
+	case Confirmed:
+		return "confirmed"
+	case Completing:
+		return "completing"
+	}
+	return "unknown"
+}
+
+// Hall order states indexed by floor and hall button
+type HallOrders [][2]HallOrderState
+
+func (h HallOrders) Clone() HallOrders {
+	clone := make(HallOrders, len(h))
+	copy(clone, h)
+	return clone
+}
+
+var hallOrders HallOrders // Guarded by stateMutex
+
+// Marks a pressed hall call as Unconfirmed. Does nothing if the call is already known.
+func PressHallOrder(call elevio.ButtonEvent) {
+	updateHallOrder(call, func(state HallOrderState) HallOrderState {
+		if state == NoOrder {
+			return Unconfirmed
+		}
+		return state
+	})
+}
+
+// Marks a served hall call as Completing
+func CompleteHallOrder(call elevio.ButtonEvent) {
+	updateHallOrder(call, func(state HallOrderState) HallOrderState {
+		if state == Unconfirmed || state == Confirmed {
+			return Completing
+		}
+		return state
+	})
+}
+
+// Returns the hall calls that are Confirmed, the calls whose lamps are lit
+func GetConfirmedHallOrders() map[elevio.ButtonEvent]bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	confirmed := make(map[elevio.ButtonEvent]bool)
+	for floor, states := range localHallOrders() {
+		for button, state := range states {
+			if state == Confirmed {
+				confirmed[elevio.ButtonEvent{Floor: floor, Button: elevio.ButtonType(button)}] = true
+			}
+		}
+	}
+	return confirmed
+}
+
+// Returns the state of a hall call as this elevator sees it
+func GetHallOrderState(call elevio.ButtonEvent) HallOrderState {
+	if call.Button == elevio.BT_Cab || !config.ButtonExists(call.Floor, call.Button) {
+		return NoOrder
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return localHallOrders()[call.Floor][call.Button]
+}
+
+func updateHallOrder(call elevio.ButtonEvent, next func(HallOrderState) HallOrderState) {
+	if call.Button == elevio.BT_Cab || !config.ButtonExists(call.Floor, call.Button) {
+		return
+	}
+	stateMutex.Lock()
+	orders := localHallOrders()
+	state := orders[call.Floor][call.Button]
+	if next(state) == state {
+		stateMutex.Unlock()
+		return
+	}
+	setHallOrder(call, next(state), "")
+	advanceHallOrders()
+	stateMutex.Unlock()
+	notifyStatusesChanged() // The lamps follow the new state at once
+	go broadcastHallOrders()
+}
+
+// The local states, sized to the building. Must be called with stateMutex held.
+func localHallOrders() HallOrders {
+	if len(hallOrders) != config.NumFloors {
+		hallOrders = make(HallOrders, config.NumFloors)
+	}
+	return hallOrders
+}
+
+// Must be called with stateMutex held
+func setHallOrder(call elevio.ButtonEvent, state HallOrderState, from string) {
+	hallOrders[call.Floor][call.Button] = state
+	log.Debug("Hall order state changed", "floor", call.Floor, "button", call.Button, "state", state, "from", from, "order", logging.OrderID(call))
+	if state == Confirmed {
+		log.Info("Hall call confirmed by every elevator", "floor", call.Floor, "button", call.Button, "order", logging.OrderID(call))
+		journal.RecordCall(journal.HallOrderConfirmed, call, logging.OrderID(call), from)
+	}
+}
+
+// Adopts the states of a peer that are ahead of the local ones. Every pair of
+// states is settled in one direction, so the two elevators always end up agreeing.
+// Returns whether a state changed. Must be called with stateMutex held.
+func mergeHallOrders(peerID string, peer HallOrders) bool {
+	if len(peer) != config.NumFloors {
+		return false
+	}
+	orders := localHallOrders()
+	changed := false
+	for floor := range orders {
+		for button := range orders[floor] {
+			mine, theirs := orders[floor][button], peer[floor][button]
+			adopt := false
+			switch mine {
+			case NoOrder:
+				adopt = theirs == Unconfirmed || theirs == Confirmed
+			case Unconfirmed:
+				adopt = theirs == Confirmed || theirs == Completing
+			case Confirmed:
+				adopt = theirs == Completing
+			case Completing:
+				// Unconfirmed is either a new press, or a peer that missed the call being served.
+				// Serving it again is safe, losing it is not.
+				adopt = theirs == NoOrder || theirs == Unconfirmed
+			}
+			if adopt {
+				setHallOrder(elevio.ButtonEvent{Floor: floor, Button: elevio.ButtonType(button)}, theirs, peerID)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// Moves calls on once every alive elevator has caught up: Unconfirmed to
+// Confirmed, and Completing to NoOrder. Returns whether a state changed.
+// Must be called with stateMutex held.
+func advanceHallOrders() bool {
+	orders := localHallOrders()
+	changed := false
+	for floor := range orders {
+		for button := range orders[floor] {
+			call := elevio.ButtonEvent{Floor: floor, Button: elevio.ButtonType(button)}
+			switch orders[floor][button] {
+			case Unconfirmed:
+				if peersHaveHallOrder(call, Unconfirmed, Confirmed) {
+					setHallOrder(call, Confirmed, "")
+					changed = true
+				}
+			case Completing:
+				if peersHaveHallOrder(call, Completing, NoOrder) {
+					setHallOrder(call, NoOrder, "")
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// Whether every peer that sends hall order states has the call in one of the
+// given states. Elevators older than protocol version 7 send none, and must not
+// hold the call back forever. An elevator of version 7 sends its states with its
+// first status.
+func peersHaveHallOrder(call elevio.ButtonEvent, states ...HallOrderState) bool {
+	for id, status := range elevatorStatuses {
+		if id == config.LocalID || len(status.HallOrders) == 0 {
+			continue
+		}
+		if len(status.HallOrders) != config.NumFloors {
+			return false
+		}
+		theirs := status.HallOrders[call.Floor][call.Button]
+		found := false
+		for _, state := range states {
+			found = found || theirs == state
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Sends the local status at once with the current hall order states, so peers
+// need not wait for the next periodic broadcast
+func broadcastHallOrders() {
+	stateMutex.Lock()
+	status, exists := elevatorStatuses[config.LocalID]
+	if !exists {
+		stateMutex.Unlock()
+		return // The first status broadcast carries the states
+	}
+	status.HallOrders = localHallOrders().Clone()
+	status.Timestamp = time.Now()
+	elevatorStatuses[config.LocalID] = status
+	stateMutex.Unlock()
+
+	for i := 0; i < 3; i++ {
+		txElevatorStatusChan <- status
+		time.Sleep(5 * time.Millisecond)
+	}
+}
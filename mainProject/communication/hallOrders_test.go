@@ -0,0 +1,205 @@
+package communication
+
+import (
+	"mainProject/config"
+	"mainProject/elevio"
+	"os"
+	"sort"
+	"testing"
+)
+
+// Set once, as the goroutines broadcasting hall order states may still read them
+func TestMain(m *testing.M) {
+	config.LocalID = "elevator_1"
+	config.NumFloors = floors
+	os.Exit(m.Run())
+}
+
+const floors = 4
+
+// The call the tests press, which exists on every building of `floors` floors
+var call = elevio.ButtonEvent{Floor: 1, Button: elevio.BT_HallUp}
+
+// -----------------------------------------------------------------------------
+// Harness
+// -----------------------------------------------------------------------------
+// Drives the hall order states of the local elevator as the receive loop of
+// RunCommunication would, without a network.
+
+type hallHarness struct {
+	t *testing.T
+}
+
+func newHallHarness(t *testing.T) *hallHarness {
+	t.Helper()
+	reset := func() {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		hallOrders = nil
+		elevatorStatuses = make(map[string]ElevatorStatus)
+	}
+	reset()
+	t.Cleanup(reset)
+	return &hallHarness{t: t}
+}
+
+// The hall order states of a peer that has `call` in `state`
+func withCall(state HallOrderState) HallOrders {
+	orders := make(HallOrders, floors)
+	orders[call.Floor][call.Button] = state
+	return orders
+}
+
+// Sets the local state of `call`, as if it had got there on its own
+func (h *hallHarness) Set(state HallOrderState) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	localHallOrders()[call.Floor][call.Button] = state
+}
+
+// Receives the statuses of `peers` with their hall order states, as the
+// receive loop does. Every status is known before any is merged, as they would
+// be after a round of broadcasts.
+func (h *hallHarness) Receive(peers map[string]HallOrders) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	ids := make([]string, 0, len(peers))
+	for id, orders := range peers {
+		elevatorStatuses[id] = ElevatorStatus{ID: id, Queue: config.NewQueue(floors), HallOrders: orders}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		mergeHallOrders(id, peers[id])
+	}
+	advanceHallOrders()
+}
+
+func (h *hallHarness) State() HallOrderState {
+	return GetHallOrderState(call)
+}
+
+// -----------------------------------------------------------------------------
+// Tests
+// -----------------------------------------------------------------------------
+
+func TestHallOrderStateFollowsPeers(t *testing.T) {
+	tests := []struct {
+		name  string
+		mine  HallOrderState
+		peers map[string]HallOrderState
+		want  HallOrderState
+	}{
+		{"unconfirmed, no peer has it", Unconfirmed, map[string]HallOrderState{"elevator_2": NoOrder, "elevator_3": NoOrder}, Unconfirmed},
+		{"unconfirmed, one peer lacks it", Unconfirmed, map[string]HallOrderState{"elevator_2": Unconfirmed, "elevator_3": NoOrder}, Unconfirmed},
+		{"unconfirmed, every peer has it", Unconfirmed, map[string]HallOrderState{"elevator_2": Unconfirmed, "elevator_3": Unconfirmed}, Confirmed},
+		{"unconfirmed, a peer confirmed it", Unconfirmed, map[string]HallOrderState{"elevator_2": Confirmed, "elevator_3": Unconfirmed}, Confirmed},
+		{"no order, a peer pressed it", NoOrder, map[string]HallOrderState{"elevator_2": Unconfirmed, "elevator_3": NoOrder}, Unconfirmed},
+		{"no order, a peer still completing", NoOrder, map[string]HallOrderState{"elevator_2": Completing, "elevator_3": NoOrder}, NoOrder},
+		{"confirmed, a peer served it", Confirmed, map[string]HallOrderState{"elevator_2": Completing, "elevator_3": Confirmed}, Completing},
+		{"completing, a peer still confirmed", Completing, map[string]HallOrderState{"elevator_2": Completing, "elevator_3": Confirmed}, Completing},
+		{"completing, every peer caught up", Completing, map[string]HallOrderState{"elevator_2": Completing, "elevator_3": NoOrder}, NoOrder},
+		{"completing, a peer pressed again", Completing, map[string]HallOrderState{"elevator_2": Unconfirmed, "elevator_3": NoOrder}, Unconfirmed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHallHarness(t)
+			h.Set(tt.mine)
+			peers := make(map[string]HallOrders)
+			for id, state := range tt.peers {
+				peers[id] = withCall(state)
+			}
+			h.Receive(peers)
+			if state := h.State(); state != tt.want {
+				t.Errorf("state = %v, want %v", state, tt.want)
+			}
+		})
+	}
+}
+
+func TestHallOrderLifecycle(t *testing.T) {
+	h := newHallHarness(t)
+	h.Receive(map[string]HallOrders{"elevator_2": withCall(NoOrder)})
+
+	PressHallOrder(call)
+	if state := h.State(); state != Unconfirmed {
+		t.Fatalf("state after a press = %v, want unconfirmed until the peer has it", state)
+	}
+	if GetConfirmedHallOrders()[call] {
+		t.Fatalf("lamp lit before every elevator has the call")
+	}
+	h.Receive(map[string]HallOrders{"elevator_2": withCall(Unconfirmed)})
+	if state := h.State(); state != Confirmed || !GetConfirmedHallOrders()[call] {
+		t.Fatalf("state = %v once the peer has the call, want confirmed with the lamp lit", state)
+	}
+
+	CompleteHallOrder(call)
+	if state := h.State(); state != Completing {
+		t.Fatalf("state after serving = %v, want completing until the peer has seen it", state)
+	}
+	if GetConfirmedHallOrders()[call] {
+		t.Errorf("lamp still lit after the call was served")
+	}
+	h.Receive(map[string]HallOrders{"elevator_2": withCall(Completing)})
+	if state := h.State(); state != NoOrder {
+		t.Fatalf("state = %v once the peer has seen the call served, want none", state)
+	}
+	h.Receive(map[string]HallOrders{"elevator_2": withCall(Completing)}) // A late copy
+	if state := h.State(); state != NoOrder {
+		t.Errorf("state = %v after a late copy from the peer, want none", state)
+	}
+}
+
+func TestHallOrderAloneConfirmsAtOnce(t *testing.T) {
+	h := newHallHarness(t)
+	PressHallOrder(call)
+	if state := h.State(); state != Confirmed {
+		t.Fatalf("state after a press without peers = %v, want confirmed", state)
+	}
+	CompleteHallOrder(call)
+	if state := h.State(); state != NoOrder {
+		t.Errorf("state after serving without peers = %v, want none", state)
+	}
+}
+
+func TestHallOrderPressWhileCompleting(t *testing.T) {
+	h := newHallHarness(t)
+	h.Receive(map[string]HallOrders{"elevator_2": withCall(Confirmed)})
+	h.Set(Completing)
+
+	PressHallOrder(call)
+	if state := h.State(); state != Completing {
+		t.Fatalf("state after a press while completing = %v, want the press ignored", state)
+	}
+	h.Receive(map[string]HallOrders{"elevator_2": withCall(NoOrder)})
+	if state := h.State(); state != NoOrder {
+		t.Fatalf("state = %v once the peer has caught up, want none", state)
+	}
+	PressHallOrder(call)
+	if state := h.State(); state != Unconfirmed {
+		t.Errorf("state after a press once the call was completed = %v, want unconfirmed", state)
+	}
+}
+
+func TestHallOrderPeersWithoutStates(t *testing.T) {
+	tests := []struct {
+		name       string
+		peerOrders HallOrders // Hall order states of elevator_3, besides elevator_2 which has the call
+		want       HallOrderState
+	}{
+		{"older than version 7", nil, Confirmed},
+		{"empty", HallOrders{}, Confirmed},
+		{"other floor count", make(HallOrders, floors+1), Unconfirmed},
+		{"without the call", withCall(NoOrder), Unconfirmed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHallHarness(t)
+			h.Set(Unconfirmed)
+			h.Receive(map[string]HallOrders{"elevator_2": withCall(Unconfirmed), "elevator_3": tt.peerOrders})
+			if state := h.State(); state != tt.want {
+				t.Errorf("state = %v, want %v", state, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package communication
+
+import (
+	"mainProject/config"
+	"sort"
+)
+
+// -----------------------------------------------------------------------------
+// Master Handoff
+// -----------------------------------------------------------------------------
+// A master shutting down for maintenance sends the statuses it keeps as backup,
+// and its own, to the next master. The next master can then restore the cab
+// calls of elevators it never saw, including those of the leaving master.
+
+// Sends the backup statuses and the local status to `target`, one message at a
+// time with `policy`, and returns when each has been acknowledged or given up
+func SendHandoff(target string, policy RetryPolicy) {
+	stateMutex.Lock()
+	statuses := make(map[string]ElevatorStatus)
+	for id, status := range backupElevatorStatuses {
+		statuses[id] = status
+	}
+	if status, exists := elevatorStatuses[config.LocalID]; exists {
+		statuses[config.LocalID] = status
+	}
+	stateMutex.Unlock()
+
+	ids := make([]string, 0, len(statuses))
+	for id := range statuses {
+		if id != target {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	log.Info("Handing over backup statuses", "target", target, "elevators", ids)
+	for _, id := range ids {
+		msg := HandoffMessage{
+			TargetID: target,
+			SenderID: config.LocalID,
+			Term:     config.MasterTerm(),
+			Status:   statuses[id],
+		}
+		handoffs.Send(msg, target, "", policy, DeliveryCallbacks{})
+	}
+}
+
+// Keeps a handed over status as backup, unless the same or a newer one is already known
+func receiveHandoff(msg HandoffMessage, txAckChan chan AckMessage) {
+	Acknowledge(msg.DeliveryID(), msg.SenderID, msg.Term, txAckChan)
+	if !FirstDelivery(msg.DeliveryID()) {
+		return
+	}
+	if len(msg.Status.Queue) != config.NumFloors {
+		log.Warn("Ignoring handed over status for another floor count", "elevator", msg.Status.ID, "sender", msg.SenderID)
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if backup, exists := backupElevatorStatuses[msg.Status.ID]; exists && !msg.Status.Timestamp.After(backup.Timestamp) {
+		return
+	}
+	backupElevatorStatuses[msg.Status.ID] = msg.Status
+	log.Info("Received backup status from the previous master", "elevator", msg.Status.ID, "sender", msg.SenderID)
+}
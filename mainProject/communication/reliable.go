@@ -0,0 +1,314 @@
+package communication
+
+import (
+	"mainProject/config"
+	"mainProject/journal"
+	"mainProject/metrics"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Reliable Delivery
+// -----------------------------------------------------------------------------
+// Assignments, raw hall calls, order statuses and handoffs are sent again until
+// the target acks them. Each carries a Delivery naming it by sender, incarnation
+// of the sender process, stream and sequence number. Receivers ack the Delivery
+// and drop copies of a Delivery already handled, so messages from different
+// elevators, or from an elevator before and after a restart, never mix.
+//
+// Each stream is sent with a Reliable, which names each message, retries it
+// with the policy given by the caller and calls back once it is acked or given up.
+//
+// Elevators older than protocol version 6 send no Delivery. Their messages are
+// named by the sequence number and whatever sender they carry, and their acks
+// by the sequence number alone. One counter is shared by every stream, so a
+// sequence number still names one pending message of this process.
+
+// Names a reliable message. Since protocol version 6.
+type Delivery struct {
+	Sender      string
+	Incarnation int64  // Start time of the sender process, so a restarted elevator does not reuse names
+	Stream      string // Kind of message, see the streams below. Also the label of the message in metrics
+	Seq         int
+}
+
+// Streams
+const (
+	assignmentStream  = "assignment"
+	revocationStream  = "revocation"
+	rawHallCallStream = "raw_hall_call"
+	orderStatusStream = "order_status"
+	handoffStream     = "handoff"
+)
+
+// Copies of an ack sent, and the pause between them
+const (
+	ackCopies   = 3
+	ackInterval = 10 * time.Millisecond
+)
+
+// A message waiting for its ack
+type pendingAck struct {
+	acked chan struct{}
+	term  int // Election term when the message was sent. Acks from older terms come from a deposed master
+}
+
+var (
+	incarnation = startedAt.UnixNano()
+
+	deliveryMutex     sync.Mutex
+	lastSeq           int
+	pendingAcks       = make(map[Delivery]pendingAck)
+	seenDeliveries    = make(map[Delivery]time.Time) // Received messages already handled
+	lastDeliverySweep time.Time
+)
+
+func newDelivery(stream string) Delivery {
+	deliveryMutex.Lock()
+	defer deliveryMutex.Unlock()
+	lastSeq++
+	return Delivery{Sender: config.LocalID, Incarnation: incarnation, Stream: stream, Seq: lastSeq}
+}
+
+// The Delivery of a message that may come from an elevator older than version 6
+func (d Delivery) orLegacy(sender string, stream string, seq int) Delivery {
+	if d.Sender != "" {
+		return d
+	}
+	return Delivery{Sender: sender, Stream: stream, Seq: seq}
+}
+
+// Names of received messages, for duplicate filtering and acks
+func (m AssignmentMessage) DeliveryID() Delivery {
+	return m.Delivery.orLegacy("", assignmentStream, m.SeqNum)
+}
+
+func (m RawHallCallMessage) DeliveryID() Delivery {
+	return m.Delivery.orLegacy(m.SenderID, rawHallCallStream, m.SeqNum)
+}
+
+func (m OrderStatusMessage) DeliveryID() Delivery {
+	return m.Delivery.orLegacy(m.SenderID, orderStatusStream, m.SeqNum)
+}
+
+func (m HandoffMessage) DeliveryID() Delivery {
+	return m.Delivery.orLegacy(m.SenderID, handoffStream, m.SeqNum)
+}
+
+// Reports whether a received message is seen for the first time, and remembers
+// it for long enough that every retry of it is recognized
+func FirstDelivery(d Delivery) bool {
+	deliveryMutex.Lock()
+	defer deliveryMutex.Unlock()
+
+	rememberFor := max(10*time.Second, 2*DeliveryTimeout())
+	if time.Since(lastDeliverySweep) > rememberFor {
+		lastDeliverySweep = time.Now()
+		for seen, at := range seenDeliveries {
+			if time.Since(at) > rememberFor {
+				delete(seenDeliveries, seen)
+			}
+		}
+	}
+	if _, seen := seenDeliveries[d]; seen {
+		metrics.DuplicatesDropped.Inc(d.Stream)
+		return false
+	}
+	seenDeliveries[d] = time.Now()
+	return true
+}
+
+// Acks a received message to its sender, with a few copies in case some are
+// lost. Acks every copy of a message, as the earlier acks may be the lost ones.
+func Acknowledge(d Delivery, target string, term int, txAckChan chan AckMessage) {
+	ack := AckMessage{TargetID: target, SeqNum: d.Seq, Term: term, Delivery: d}
+	go func() {
+		for i := 0; i < ackCopies; i++ {
+			txAckChan <- ack
+			time.Sleep(ackInterval)
+		}
+	}()
+}
+
+// Marks the message named by a received ack as delivered
+func handleAck(ack AckMessage) {
+	if ack.TargetID != config.LocalID {
+		return
+	}
+	deliveryMutex.Lock()
+	defer deliveryMutex.Unlock()
+	d := ack.Delivery
+	if d.Sender == "" {
+		// An ack from an elevator older than version 6 names the sequence number only
+		for pending := range pendingAcks {
+			if pending.Seq == ack.SeqNum {
+				d = pending
+			}
+		}
+	}
+	pending, exists := pendingAcks[d]
+	if !exists {
+		return
+	}
+	if ack.Term < pending.term {
+		log.Debug("Ignoring ack from an older term", "stream", d.Stream, "seq", d.Seq, "term", ack.Term, "message_term", pending.term)
+		return
+	}
+	close(pending.acked)
+	delete(pendingAcks, d)
+}
+
+// -----------------------------------------------------------------------------
+// Reliable Senders
+// -----------------------------------------------------------------------------
+
+// A message that can be sent reliably, named by the Delivery it carries
+type ReliableMessage[T any] interface {
+	DeliveryID() Delivery
+	withDelivery(d Delivery) T // The message named by `d`
+}
+
+func (m AssignmentMessage) withDelivery(d Delivery) AssignmentMessage {
+	m.Delivery, m.SeqNum = d, d.Seq
+	return m
+}
+
+func (m RawHallCallMessage) withDelivery(d Delivery) RawHallCallMessage {
+	m.Delivery, m.SeqNum = d, d.Seq
+	return m
+}
+
+func (m OrderStatusMessage) withDelivery(d Delivery) OrderStatusMessage {
+	m.Delivery, m.SeqNum = d, d.Seq
+	return m
+}
+
+func (m HandoffMessage) withDelivery(d Delivery) HandoffMessage {
+	m.Delivery, m.SeqNum = d, d.Seq
+	return m
+}
+
+// How a reliable message is sent again. Zero fields take the values of the
+// Retry configuration when the message is sent.
+type RetryPolicy struct {
+	MaxRetries int           // Attempts before the message is given up
+	Interval   time.Duration // Wait for an ack before the first retry
+	Backoff    int           // Factor the interval grows by after each retry
+	Redundancy int           // Copies sent per attempt
+}
+
+func (p RetryPolicy) orConfigured() RetryPolicy {
+	retry := config.Cfg.Retry
+	if p.MaxRetries == 0 {
+		p.MaxRetries = retry.MaxRetries
+	}
+	if p.Interval == 0 {
+		p.Interval = retry.RetryInterval.Duration
+	}
+	if p.Backoff == 0 {
+		p.Backoff = retry.ExponentialBackoff
+	}
+	if p.Redundancy == 0 {
+		p.Redundancy = retry.RedundancyFactor
+	}
+	return p
+}
+
+// The longest a message can take before it is acked or given up
+func (p RetryPolicy) DeliveryTimeout() time.Duration {
+	p = p.orConfigured()
+	timeout := time.Duration(0)
+	interval := p.Interval
+	for i := 0; i < p.MaxRetries; i++ {
+		timeout += interval
+		interval *= time.Duration(p.Backoff)
+	}
+	return timeout
+}
+
+// The longest a message sent with the configured retries can take before it is acked or given up
+func DeliveryTimeout() time.Duration {
+	return RetryPolicy{}.DeliveryTimeout()
+}
+
+// Called once a message is acked, or once it is given up. Either may be nil.
+type DeliveryCallbacks struct {
+	OnDelivered func()
+	OnFailed    func()
+}
+
+// Sends the messages of one stream on `tx`, each until it is acked
+type Reliable[T ReliableMessage[T]] struct {
+	stream string
+	tx     chan T
+}
+
+func NewReliable[T ReliableMessage[T]](stream string, tx chan T) *Reliable[T] {
+	return &Reliable[T]{stream: stream, tx: tx}
+}
+
+// Names `msg` as the next message of the stream. Only needed when the name is
+// used before the message is sent, as Send names the messages it gets unnamed.
+func (r *Reliable[T]) Stamp(msg T) T {
+	return msg.withDelivery(newDelivery(r.stream))
+}
+
+// Sends `msg` to `targetID` until it is acked or given up, calls the matching
+// callback, and returns whether it was acked. Retries with `policy`, where the
+// zero policy is the Retry configuration. Blocks meanwhile, so it is usually
+// run in its own goroutine.
+func (r *Reliable[T]) Send(msg T, targetID string, orderID string, policy RetryPolicy, callbacks DeliveryCallbacks) bool {
+	d := msg.DeliveryID()
+	if d.Incarnation == 0 {
+		msg = r.Stamp(msg)
+		d = msg.DeliveryID()
+	} else if d.Stream != r.stream || d.Sender != config.LocalID {
+		// Another stream could ack it, or drop it as a duplicate of its own message
+		log.Error("Not sending a message named for another stream", "stream", r.stream, "message_stream", d.Stream, "sender", d.Sender, "seq", d.Seq, "order", orderID)
+		if callbacks.OnFailed != nil {
+			callbacks.OnFailed()
+		}
+		return false
+	}
+	policy = policy.orConfigured()
+	msgLog := log.With("stream", d.Stream, "seq", d.Seq, "target", targetID, "order", orderID)
+	ackChan := make(chan struct{})
+	deliveryMutex.Lock()
+	pendingAcks[d] = pendingAck{acked: ackChan, term: config.MasterTerm()}
+	deliveryMutex.Unlock()
+
+	interval := policy.Interval
+	for retries := 0; retries < policy.MaxRetries; {
+		for i := 0; i < policy.Redundancy; i++ {
+			r.tx <- msg
+		}
+
+		select {
+		case <-ackChan:
+			msgLog.Debug("Ack received")
+			journal.Record(journal.Event{Kind: journal.MessageAcked, OrderID: orderID, Peer: targetID, Message: d.Stream, SeqNum: d.Seq})
+			if callbacks.OnDelivered != nil {
+				callbacks.OnDelivered()
+			}
+			return true
+		case <-time.After(interval):
+			retries++
+			interval *= time.Duration(policy.Backoff)
+			if retries < policy.MaxRetries {
+				metrics.MessageRetries.Inc(d.Stream)
+				msgLog.Warn("No ack, retrying", "attempt", retries, "maxRetries", policy.MaxRetries)
+			}
+		}
+	}
+	deliveryMutex.Lock()
+	delete(pendingAcks, d)
+	deliveryMutex.Unlock()
+	metrics.MessageFailures.Inc(d.Stream)
+	msgLog.Error("Message could not be delivered", "attempts", policy.MaxRetries)
+	journal.Record(journal.Event{Kind: journal.MessageFailed, OrderID: orderID, Peer: targetID, Message: d.Stream, SeqNum: d.Seq})
+	if callbacks.OnFailed != nil {
+		callbacks.OnFailed()
+	}
+	return false
+}
@@ -1,115 +1,98 @@
 package communication
 
 import (
-	"fmt"
 	"mainProject/config"
 	"mainProject/elevio"
-	"time"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/metrics"
+)
+
+// Senders of the reliable messages, see reliable.go
+var (
+	assignments   = NewReliable(assignmentStream, txAssignmentChan)
+	revocations   = NewReliable(revocationStream, txAssignmentChan)
+	rawHallCalls  = NewReliable(rawHallCallStream, txRawHallCallChan)
+	orderStatuses = NewReliable(orderStatusStream, txOrderStatusChan)
+	handoffs      = NewReliable(handoffStream, txHandoffChan)
 )
 
 // -----------------------------------------------------------------------------
 // Assignment and Hall Call Management
 // -----------------------------------------------------------------------------
-// Sends an assignment message to a specific elevator for a hall call.
-func SendAssignment(targetElevator string, floor int, button elevio.ButtonType) {	
-	seqNumAssignmentCounter++
+// Sends an assignment message to a specific elevator for a hall call, and
+// returns once it is acked or given up. `callbacks` tell the caller which.
+func SendAssignment(targetElevator string, floor int, button elevio.ButtonType, callbacks DeliveryCallbacks) {	
 	hallCall := AssignmentMessage{
 		TargetID: targetElevator,
 		Floor:    floor,
 		Button:   button,
-		SeqNum:   seqNumAssignmentCounter,
+		PressedAt: metrics.HallCallPressedAt(elevio.ButtonEvent{Floor: floor, Button: button}),
+		OrderID:  logging.OrderID(elevio.ButtonEvent{Floor: floor, Button: button}),
+		Term:     config.MasterTerm(),
+	}
+	assignments.Send(hallCall, targetElevator, hallCall.OrderID, RetryPolicy{}, callbacks)
+}
+
+// Tells an elevator to drop a hall call that has been given to another elevator
+func SendRevocation(targetElevator string, hallCall elevio.ButtonEvent) {
+	msg := AssignmentMessage{
+		TargetID: targetElevator,
+		Floor:    hallCall.Floor,
+		Button:   hallCall.Button,
+		OrderID:  logging.OrderID(hallCall),
+		Term:     config.MasterTerm(),
+		Revoke:   true,
 	}
-	go reliablePacketTransmit(hallCall, txAssignmentChan, hallCall.SeqNum, targetElevator, "Assignment Message")
+	revocations.Send(msg, targetElevator, msg.OrderID, RetryPolicy{}, DeliveryCallbacks{})
 }
 // Sends a raw hall call event to the master elevator for assignment.
 func SendRawHallCall(hallCall elevio.ButtonEvent) {
-    if config.LocalID == config.MasterID {
+    if config.LocalID == config.MasterID() {
         return
     }
-    seqNumRawCallCounter++
-    msg := RawHallCallMessage{
-		TargetID: config.MasterID, 
+    msg := rawHallCalls.Stamp(RawHallCallMessage{
+		TargetID: config.MasterID(), 
 		SenderID: config.LocalID, 
 		Floor: 	  hallCall.Floor, 
 		Button:	  hallCall.Button, 
-		SeqNum:	  seqNumRawCallCounter,
-	}
-	go reliablePacketTransmit(msg, txRawHallCallChan, msg.SeqNum, config.MasterID, "Raw Hall Call")
+		PressedAt: metrics.HallCallPressedAt(hallCall),
+		OrderID:  logging.OrderID(hallCall),
+	})
+	journal.Record(journal.Event{Kind: journal.RawHallCallSent, Call: &hallCall, OrderID: msg.OrderID, Peer: msg.TargetID, SeqNum: msg.SeqNum})
+	go rawHallCalls.Send(msg, config.MasterID(), msg.OrderID, RetryPolicy{}, DeliveryCallbacks{})
 }
 
 // -----------------------------------------------------------------------------
-// Light and Order Status Management
+// Order Status Management
 // -----------------------------------------------------------------------------
 func SendOrderStatus(msg OrderStatusMessage, orderStatusChan chan OrderStatusMessage) {
-	SeqOrderStatusCounter++
-	msg.SeqNum = SeqOrderStatusCounter
+	if msg.Status == Finished {
+		CompleteHallOrder(msg.ButtonEvent)
+	}
+	if config.Cfg.AssignmentMode == config.PeerAssignment {
+		// There is no master to tell, the hall order states carry the news
+		if msg.Status == Finished {
+			metrics.HallCallsCompleted.Inc()
+			metrics.ForgetHallCall(msg.ButtonEvent)
+			logging.ForgetOrderID(msg.ButtonEvent)
+		}
+		return
+	}
+	msg = orderStatuses.Stamp(msg) // Also named when kept locally, for the duplicate filter of the master
+	if msg.OrderID == "" {
+		msg.OrderID = logging.OrderID(msg.ButtonEvent)
+	}
+	if msg.Status == Finished {
+		logging.ForgetOrderID(msg.ButtonEvent) // The ID travels on with the message
+	}
+
+	journal.Record(journal.Event{Kind: journal.OrderStatusSent, Call: &msg.ButtonEvent, OrderID: msg.OrderID, Peer: config.MasterID(), SeqNum: msg.SeqNum, Detail: msg.Status.String()})
 
 	//Do not send orderStatus updates over network if the master itself is the recipient
-	if config.LocalID == config.MasterID {
+	if config.LocalID == config.MasterID() {
 		orderStatusChan <- msg
 	} else {
-		go reliablePacketTransmit(msg, txOrderStatusChan, msg.SeqNum, config.MasterID, "Order Status Message")
-	}
-}
-
-func SendLightOrder(buttonLight elevio.ButtonEvent, lightOnOrOff LightStatus, statusSenderID string) {
-	for _, elevator := range elevatorStatuses {
-		if elevator.ID == config.LocalID || elevator.ID == statusSenderID {
-			continue
-		}
-		seqLightCounter++
-		msg := LightOrderMessage{
-			TargetID:    elevator.ID,
-			ButtonEvent: buttonLight,
-			Light:       lightOnOrOff,
-			SeqNum:      seqLightCounter,
-		}
-		go reliablePacketTransmit(msg, txLightChan, msg.SeqNum, msg.TargetID, "Light Order")
+		go orderStatuses.Send(msg, config.MasterID(), msg.OrderID, RetryPolicy{}, DeliveryCallbacks{})
 	}
 }
-
-// -----------------------------------------------------------------------------------------------------------
-// Combined Message Handling. Provides a common system for message transmitting and implements an ack system
-// -----------------------------------------------------------------------------------------------------------
-func reliablePacketTransmit(msg interface{}, txChan interface{}, seqNum int, targetID string, description string) {
-    ackChan := make(chan struct{})
-    pendingAcksMutex.Lock()
-    pendingAcks[seqNum] = ackChan
-    pendingAcksMutex.Unlock()
-
-	//Variables may be tuned based on observed performance
-	messageMaxRetries          := 5
-    messageRetryInterval       := 200 * time.Millisecond
-    messageExponentialBackoff  := 2
-	messageRedundancyFactor    := 4
-    retries := 0
-
-    for retries < messageMaxRetries {
-        for i := 0; i < messageRedundancyFactor; i++ {
-            switch ch := txChan.(type) { 
-            case chan AssignmentMessage:
-                ch <- msg.(AssignmentMessage)
-            case chan RawHallCallMessage:
-                ch <- msg.(RawHallCallMessage)
-            case chan OrderStatusMessage:
-                ch <- msg.(OrderStatusMessage)
-            case chan LightOrderMessage:
-                ch <- msg.(LightOrderMessage)
-            }
-        }
-
-        select {
-        case <-ackChan:
-            fmt.Printf("[ACK Received] %s | SeqNum: %d | Target: %s\n", description, seqNum, targetID)
-			return
-        case <-time.After(messageRetryInterval):
-            retries++
-            messageRetryInterval *= time.Duration(messageExponentialBackoff)
-            fmt.Printf("[Retrying] %s | SeqNum: %d | Attempt: %d/%d\n", description, seqNum, retries, messageMaxRetries)
-        }
-    }
-    fmt.Printf("[Failed] %s | SeqNum: %d | Could not be delivered after %d attempts.\n", description, seqNum, messageMaxRetries)
-    pendingAcksMutex.Lock()
-    delete(pendingAcks, seqNum)
-    pendingAcksMutex.Unlock()
-}
\ No newline at end of file
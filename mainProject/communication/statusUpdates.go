@@ -2,9 +2,13 @@ package communication
 
 import (
 	"mainProject/config"
+	"sort"
 	"time"
 )
 
+// When this process started, advertised for the uptime election rule
+var startedAt = time.Now()
+
 // Elevator State Management
 // -----------------------------------------------------------------------------
 // Updates the global elevatorStatuses map when new elevators join or existing elevators disconnect.
@@ -29,42 +33,105 @@ func UpdateElevatorStates(newPeers []string, lostPeers []string) {
 	for _, lostPeer := range lostPeers {
 		delete(elevatorStatuses, lostPeer)
 	}
+	if len(lostPeers) > 0 && advanceHallOrders() {
+		go broadcastHallOrders() // Calls the lost elevators held back
+	}
 }
 
 func GetBackupState() map[string]ElevatorStatus {
 	return backupElevatorStatuses
 }
 
-// Sends a copy of the current status map to the elevatorStatusesChan for internal use (e.g., order assignment, master election)
+// Records the elevators currently seen on the network, as reported by the peer receiver
+func SetPeers(peers []string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	currentPeers = append([]string{}, peers...)
+}
+
+func GetPeers() []string {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]string{}, currentPeers...)
+}
+
+// Returns a copy of the statuses of all known elevators
+func GetElevatorStatuses() map[string]ElevatorStatus {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	copyMap := make(map[string]ElevatorStatus)
+	for k, v := range elevatorStatuses {
+		copyMap[k] = v
+	}
+	return copyMap
+}
+
+// Returns the sequence numbers of messages still waiting for an ack, in increasing order
+func GetPendingAcks() []int {
+	deliveryMutex.Lock()
+	defer deliveryMutex.Unlock()
+	seqNums := make([]int, 0, len(pendingAcks))
+	for d := range pendingAcks {
+		seqNums = append(seqNums, d.Seq)
+	}
+	sort.Ints(seqNums)
+	return seqNums
+}
+
+// Set when the statuses changed since they were last sent to order assignment.
+// One pending change covers any number, as the statuses are copied when sent.
+var statusesChanged = make(chan struct{}, 1)
+
+func notifyStatusesChanged() {
+    select {
+    case statusesChanged <- struct{}{}:
+    default: // Already pending
+    }
+}
+
+// Sends a copy of the current status map to the elevatorStatusesChan for internal use (e.g., order assignment, master election),
+// periodically and when the statuses change. A single sender keeps the copies in order.
 func startPeriodicLocalStatusUpdates(elevatorStatusesChan chan map[string]ElevatorStatus) {
     go func() {
+        ticker := time.NewTicker(500 * time.Millisecond)
+        defer ticker.Stop()
         for {
-            stateMutex.Lock()
-            copyMap := make(map[string]ElevatorStatus)
-            for k, v := range elevatorStatuses {
-                copyMap[k] = v
+            elevatorStatusesChan <- GetElevatorStatuses()
+            select {
+            case <-ticker.C:
+            case <-statusesChanged:
             }
-            stateMutex.Unlock()
-            elevatorStatusesChan <- copyMap 
-            time.Sleep(500 * time.Millisecond) 
         }
     }()
 }
 
-// Broadcasts local elevator state to other elevators and updates the global elevatorStatuses map
-// Sends immediate status updates when critical events happen (e.g., a floor is reached, a hall call is assigned).
-func BroadcastElevatorStatus(e config.Elevator, isCriticalEvent bool) {
+// Makes `e` the status of the local elevator in the elevatorStatuses map, and returns it
+func SetLocalStatus(e config.Elevator) ElevatorStatus {
     stateMutex.Lock()
+    defer stateMutex.Unlock()
     localElevatorStatus := ElevatorStatus{
         ID:        config.LocalID,
         Floor:     e.Floor,
 		State:     e.State,
         Direction: e.Direction,
-        Queue:     e.Queue,
+        Queue:     e.Queue.Clone(),
+        Available: e.State.IsAvailable(),
         Timestamp: time.Now(),
+        MasterID:   config.MasterID(),
+        MasterTerm: config.MasterTerm(),
+        Obstructed: e.Obstructed,
+        StartedAt:  startedAt,
+        Priority:   config.Cfg.Election.Priority,
+        HallOrders: localHallOrders().Clone(),
     }
     elevatorStatuses[config.LocalID] = localElevatorStatus
-    stateMutex.Unlock()
+    return localElevatorStatus
+}
+
+// Broadcasts local elevator state to other elevators and updates the global elevatorStatuses map
+// Sends immediate status updates when critical events happen (e.g., a floor is reached, a hall call is assigned).
+func BroadcastElevatorStatus(e config.Elevator, isCriticalEvent bool) {
+    localElevatorStatus := SetLocalStatus(e)
 
     redundancyFactor := 3  // For periodic broadcasts
     if isCriticalEvent {
@@ -75,4 +142,4 @@ func BroadcastElevatorStatus(e config.Elevator, isCriticalEvent bool) {
         txElevatorStatusChan <- localElevatorStatus
         time.Sleep(5 * time.Millisecond)
     }
-}
+}
\ No newline at end of file
@@ -14,40 +14,81 @@ const (
 	Idle ElevatorState = iota
 	Moving
 	DoorOpen
+	EmergencyStop
+	OutOfService
 )
 
+func (s ElevatorState) String() string {
+	switch s {
+	case Idle:
+		return "Idle"
+	case Moving:
+		return "Moving"
+	case DoorOpen:
+		return "DoorOpen"
+	case EmergencyStop:
+		return "EmergencyStop"
+	case OutOfService:
+		return "OutOfService"
+	}
+	return fmt.Sprintf("ElevatorState(%d)", int(s))
+}
+
+// Whether an elevator in this state can be given hall calls
+func (s ElevatorState) IsAvailable() bool {
+	return s != EmergencyStop && s != OutOfService
+}
+
 type Elevator struct {
 	Floor       int
 	Direction   elevio.MotorDirection
-	Queue       [NumFloors][NumButtons]bool
+	Queue       Queue
 	State       ElevatorState
 	Obstructed  bool
 }
 
-const (
-	NumFloors  = 4
-	NumButtons = 3
-	DoorOpenTime = 3 //Seconds
-)
+const NumButtons = 3
+
+var NumFloors = Cfg.NumFloors // Set from the configuration, every queue is sized by it
+
+var Cfg = Default() // The active configuration, set by InitConfig
 
 var LocalID string
-var MasterID string
+var ElevatorAddr string // Address of the elevator server the driver connects to
+var StateDir string     // Directory for files that must survive a restart, e.g. saved cab calls. Empty disables them
+
+// Timing of the elevator, from the configuration
+var (
+	DoorOpenTime         = Cfg.DoorOpenTime.Duration
+	NotMovingTimeLimit   = Cfg.NotMovingTimeLimit.Duration
+	ObstructionTimeLimit = Cfg.ObstructionTimeLimit.Duration
+)
 
-// Initialize LocalID based on hostname
+// Loads the configuration from the configuration file, environment and command-line flags.
+// Exits if it is invalid, as nothing can run on a broken configuration.
 func InitConfig() {
-	port := os.Getenv("ELEVATOR_PORT")
-	if port == "" {
-    	port = "15657" // Default
+	cfg, err := Load(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
 	}
-	elevio.Init("localhost:" + port, NumFloors)
+	Apply(cfg)
+}
 
+// Makes `cfg` the active configuration
+func Apply(cfg Config) {
 	// Allow for multiple elevators on the same machine
-	if id := os.Getenv("ELEVATOR_ID"); id != "" {
-		LocalID = id
-	} else {
+	if cfg.ID == "" {
 		// Add random number to LocalID to avoid conflicts
 		rand.New(rand.NewSource(time.Now().UnixNano()))
-		LocalID = fmt.Sprintf("%s_%d", LocalID, rand.Intn(1000))
+		cfg.ID = fmt.Sprintf("%s_%d", LocalID, rand.Intn(1000))
 	}
-	fmt.Printf("This elevator's ID: %s\n", LocalID)
-}
\ No newline at end of file
+	Cfg = cfg
+	LocalID = cfg.ID
+	ElevatorAddr = fmt.Sprintf("localhost:%d", cfg.ElevatorPort)
+	StateDir = cfg.StateDir
+	NumFloors = cfg.NumFloors
+	DoorOpenTime = cfg.DoorOpenTime.Duration
+	NotMovingTimeLimit = cfg.NotMovingTimeLimit.Duration
+	ObstructionTimeLimit = cfg.ObstructionTimeLimit.Duration
+}
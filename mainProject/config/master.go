@@ -0,0 +1,38 @@
+package config
+
+import "sync"
+
+// The master is set by the election goroutine and read by every other, so it is
+// only reached through these functions
+var (
+	masterID    string
+	masterTerm  int
+	masterMutex sync.Mutex
+)
+
+// The master this elevator follows, "" if there is none
+func MasterID() string {
+	masterMutex.Lock()
+	defer masterMutex.Unlock()
+	return masterID
+}
+
+// Election term of MasterID. Messages from the master carry it, and older terms are rejected
+func MasterTerm() int {
+	masterMutex.Lock()
+	defer masterMutex.Unlock()
+	return masterTerm
+}
+
+// The master and its term, read together
+func Master() (string, int) {
+	masterMutex.Lock()
+	defer masterMutex.Unlock()
+	return masterID, masterTerm
+}
+
+func SetMaster(id string, term int) {
+	masterMutex.Lock()
+	defer masterMutex.Unlock()
+	masterID, masterTerm = id, term
+}
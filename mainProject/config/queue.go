@@ -0,0 +1,33 @@
+package config
+
+import "mainProject/elevio"
+
+// Queue holds the orders of an elevator, indexed by floor and button.
+// It is sized at startup from the configured number of floors.
+type Queue [][NumButtons]bool
+
+func NewQueue(numFloors int) Queue {
+	return make(Queue, numFloors)
+}
+
+// Queues are slices, so a copy must be cloned before it is modified or handed to another goroutine
+func (q Queue) Clone() Queue {
+	clone := make(Queue, len(q))
+	copy(clone, q)
+	return clone
+}
+
+// Whether the building has the given button. The bottom floor has no hall-down
+// button and the top floor has no hall-up button.
+func ButtonExists(floor int, button elevio.ButtonType) bool {
+	if floor < 0 || floor >= NumFloors || button < 0 || button >= NumButtons {
+		return false
+	}
+	if floor == 0 && button == elevio.BT_HallDown {
+		return false
+	}
+	if floor == NumFloors-1 && button == elevio.BT_HallUp {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,423 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Typed configuration shared by the elevator binary and the supervisor
+// -----------------------------------------------------------------------------
+// Values are taken from, in increasing order of precedence: the defaults below,
+// a JSON configuration file (-config or ELEVATOR_CONFIG), the ELEVATOR_ID /
+// ELEVATOR_PORT / ELEVATOR_STATE_DIR environment variables, and command-line flags.
+
+type Config struct {
+	File         string `json:"-"` // Configuration file the values were loaded from, if any
+	ID           string
+	ElevatorPort int    // TCP port of the elevator server on localhost
+	StateDir     string // Directory for files that must survive a restart. Empty disables them
+	HTTPAddr     string // Listen address of the status and control API, such as "127.0.0.1:8080". Empty disables it
+	JournalFile  string // File the order event journal is appended to. Empty disables it
+
+	AssignmentMode string // How hall calls are assigned: master or peer
+
+	NumFloors            int
+	DoorOpenTime         Duration
+	NotMovingTimeLimit   Duration // Time between floors before the motor is considered stalled
+	ObstructionTimeLimit Duration // Time the door may be obstructed before the elevator is taken out of service
+
+	Network  NetworkConfig
+	Election ElectionConfig
+	Retry    RetryConfig
+	Log      LogConfig
+}
+
+type NetworkConfig struct {
+	BroadcastPort   int // Elevator status broadcasts
+	PeerPort        int // Peer heartbeats
+	AssignmentPort  int // Hall call assignments from the master
+	RawHallCallPort int // Hall calls forwarded from slaves to the master
+	AckPort         int // Acknowledgements
+	StatusPort      int // Order status messages
+	LightPort       int // Unused, hall lamps follow the hall order states in the statuses. Kept so older configuration files load
+	ElectionPort    int // Master election announcements
+
+	PeerInterval Duration // Time between peer heartbeats
+	PeerTimeout  Duration // Time without heartbeats before a peer is considered lost
+
+	MasterHeartbeat Duration // Time between announcements from the master
+	MasterTimeout   Duration // Time without announcements before the master is considered lost
+
+	ProtocolVersion int    // Wire protocol version sent. Set to an older version while a fleet is upgraded
+	Codec           string // Encoding of the messages sent: json or binary. Every codec is read
+
+	MaxMessageSize  int      // Longest message in bytes. Messages longer than a datagram are sent in fragments
+	FragmentTimeout Duration // Time to wait for the missing fragments of a message before dropping it
+
+	AuthMode     string   // Message authentication: off, sign (sign, still read unsigned messages) or require
+	AuthKeyFile  string   // File holding the key shared by every elevator, read at startup
+	AuthKey      []byte   `json:"-"` // Read from AuthKeyFile, never from the configuration file itself
+	ReplayWindow Duration // How old a signed message may be, and how long its nonce is remembered
+}
+
+// Wire protocol versions, see network/bcast/protocol.go
+const (
+	LegacyProtocol  = 1 // Messages tagged with their Go type name, without a version
+	CurrentProtocol = 8 // Version 2 added the versioned envelope with registered type names, 3 the binary codec, 4 fragments, 5 signed messages, 6 delivery IDs, 7 hall order states, 8 signed peer heartbeats
+)
+
+// Message encodings, see network/bcast/codec.go
+var WireCodecs = []string{"json", "binary"}
+
+// Message authentication modes, see network/bcast/auth.go
+var AuthModes = []string{"off", "sign", "require"}
+
+// Assignment modes
+const (
+	MasterAssignment = "master" // An elected master assigns every hall call
+	PeerAssignment   = "peer"   // Every elevator shares the hall calls and assigns them the same way, without a master
+)
+
+// How the elevator that runs for master is chosen. Every elevator must use the same policy.
+type ElectionConfig struct {
+	Policy   []string // Rules applied in order until one prefers a candidate: healthy, priority, uptime or lowest-id
+	Priority int      // Static priority of this elevator, advertised in its status. Higher is preferred by the priority rule
+}
+
+// Rules an election policy can be made of
+var ElectionRules = []string{"healthy", "priority", "uptime", "lowest-id"}
+
+// Parameters for reliable message transmission
+type RetryConfig struct {
+	MaxRetries         int
+	RetryInterval      Duration // Wait for an ack before the first retry
+	ExponentialBackoff int      // Factor the retry interval grows by after each retry
+	RedundancyFactor   int      // Copies sent per attempt
+}
+
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+}
+
+// Duration is a time.Duration written as a string such as "3s" or "500ms" in the configuration file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"3s\", got %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func Default() Config {
+	return Config{
+		ElevatorPort:         15657,
+		StateDir:             ".",
+		AssignmentMode:       MasterAssignment,
+		NumFloors:            4,
+		DoorOpenTime:         Duration{3 * time.Second},
+		NotMovingTimeLimit:   Duration{8 * time.Second},
+		ObstructionTimeLimit: Duration{4 * time.Second},
+		Network: NetworkConfig{
+			BroadcastPort:   30000,
+			PeerPort:        30001,
+			AssignmentPort:  30002,
+			RawHallCallPort: 30003,
+			AckPort:         30004,
+			StatusPort:      30005,
+			LightPort:       30006,
+			ElectionPort:    30007,
+			PeerInterval:    Duration{15 * time.Millisecond},
+			PeerTimeout:     Duration{2000 * time.Millisecond},
+			MasterHeartbeat: Duration{200 * time.Millisecond},
+			MasterTimeout:   Duration{2000 * time.Millisecond},
+			ProtocolVersion: CurrentProtocol,
+			Codec:           "json",
+			MaxMessageSize:  65536,
+			FragmentTimeout: Duration{time.Second},
+			AuthMode:        "off",
+			ReplayWindow:    Duration{5 * time.Second},
+		},
+		Election: ElectionConfig{
+			Policy: []string{"lowest-id"},
+		},
+		Retry: RetryConfig{
+			MaxRetries:         5,
+			RetryInterval:      Duration{200 * time.Millisecond},
+			ExponentialBackoff: 2,
+			RedundancyFactor:   4,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// Builds the configuration from defaults, the configuration file, the environment and `args`
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("elevator", flag.ContinueOnError)
+	configFile := flags.String("config", "", "Path to a JSON configuration file")
+	id := flags.String("id", "", "Unique ID of this elevator")
+	port := flags.Int("port", 0, "TCP port of the elevator server")
+	stateDir := flags.String("state-dir", "", "Directory for files that must survive a restart")
+	httpAddr := flags.String("http", "", "Listen address of the status and control API, such as 127.0.0.1:8080")
+	journalFile := flags.String("journal", "", "File to append the order event journal to")
+	assignmentMode := flags.String("assignment-mode", "", "How hall calls are assigned: master or peer")
+	numFloors := flags.Int("floors", 0, "Number of floors")
+	doorOpenTime := flags.Duration("door-open-time", 0, "Time the door stays open")
+	notMovingTimeLimit := flags.Duration("not-moving-limit", 0, "Time between floors before the motor is considered stalled")
+	obstructionTimeLimit := flags.Duration("obstruction-limit", 0, "Time the door may be obstructed before going out of service")
+	basePort := flags.Int("base-port", 0, "First of the eight consecutive UDP ports used for elevator messages")
+	peerInterval := flags.Duration("peer-interval", 0, "Time between peer heartbeats")
+	peerTimeout := flags.Duration("peer-timeout", 0, "Time without heartbeats before a peer is lost")
+	maxRetries := flags.Int("max-retries", 0, "Attempts before a reliable message is given up")
+	electionPolicy := flags.String("election-policy", "", "Comma-separated rules choosing who runs for master: healthy, priority, uptime, lowest-id")
+	priority := flags.Int("priority", 0, "Static priority of this elevator in master elections")
+	retryInterval := flags.Duration("retry-interval", 0, "Wait for an ack before the first retry")
+	protocolVersion := flags.Int("protocol-version", 0, "Wire protocol version sent, older while a fleet is upgraded")
+	codec := flags.String("codec", "", "Encoding of the messages sent: json or binary")
+	authMode := flags.String("auth-mode", "", "Message authentication: off, sign or require")
+	authKeyFile := flags.String("auth-key-file", "", "File holding the key shared by every elevator")
+	logLevel := flags.String("log-level", "", "Minimum level logged: debug, info, warn or error")
+	logFormat := flags.String("log-format", "", "Log output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	cfg.File = os.Getenv("ELEVATOR_CONFIG")
+	if *configFile != "" {
+		cfg.File = *configFile
+	}
+	if cfg.File != "" {
+		if err := loadFile(cfg.File, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if env := os.Getenv("ELEVATOR_ID"); env != "" {
+		cfg.ID = env
+	}
+	if env := os.Getenv("ELEVATOR_PORT"); env != "" {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			return cfg, fmt.Errorf("ELEVATOR_PORT must be a number, got %q", env)
+		}
+		cfg.ElevatorPort = p
+	}
+	if env := os.Getenv("ELEVATOR_STATE_DIR"); env != "" {
+		cfg.StateDir = env
+	}
+	if env := os.Getenv("ELEVATOR_AUTH_KEY_FILE"); env != "" {
+		cfg.Network.AuthKeyFile = env
+	}
+
+	// Only flags given on the command line override the values above
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "id":
+			cfg.ID = *id
+		case "port":
+			cfg.ElevatorPort = *port
+		case "state-dir":
+			cfg.StateDir = *stateDir
+		case "http":
+			cfg.HTTPAddr = *httpAddr
+		case "journal":
+			cfg.JournalFile = *journalFile
+		case "assignment-mode":
+			cfg.AssignmentMode = *assignmentMode
+		case "floors":
+			cfg.NumFloors = *numFloors
+		case "door-open-time":
+			cfg.DoorOpenTime.Duration = *doorOpenTime
+		case "not-moving-limit":
+			cfg.NotMovingTimeLimit.Duration = *notMovingTimeLimit
+		case "obstruction-limit":
+			cfg.ObstructionTimeLimit.Duration = *obstructionTimeLimit
+		case "base-port":
+			cfg.Network.BroadcastPort = *basePort
+			cfg.Network.PeerPort = *basePort + 1
+			cfg.Network.AssignmentPort = *basePort + 2
+			cfg.Network.RawHallCallPort = *basePort + 3
+			cfg.Network.AckPort = *basePort + 4
+			cfg.Network.StatusPort = *basePort + 5
+			cfg.Network.LightPort = *basePort + 6
+			cfg.Network.ElectionPort = *basePort + 7
+		case "peer-interval":
+			cfg.Network.PeerInterval.Duration = *peerInterval
+		case "peer-timeout":
+			cfg.Network.PeerTimeout.Duration = *peerTimeout
+		case "protocol-version":
+			cfg.Network.ProtocolVersion = *protocolVersion
+		case "codec":
+			cfg.Network.Codec = *codec
+		case "auth-mode":
+			cfg.Network.AuthMode = *authMode
+		case "auth-key-file":
+			cfg.Network.AuthKeyFile = *authKeyFile
+		case "election-policy":
+			cfg.Election.Policy = splitList(*electionPolicy)
+		case "priority":
+			cfg.Election.Priority = *priority
+		case "max-retries":
+			cfg.Retry.MaxRetries = *maxRetries
+		case "retry-interval":
+			cfg.Retry.RetryInterval.Duration = *retryInterval
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		}
+	})
+
+	if cfg.Network.AuthKeyFile != "" {
+		key, err := os.ReadFile(cfg.Network.AuthKeyFile)
+		if err != nil {
+			return cfg, fmt.Errorf("could not read the authentication key: %w", err)
+		}
+		cfg.Network.AuthKey = bytes.TrimSpace(key)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields() // Catch misspelled settings instead of silently using defaults
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Splits a comma-separated flag value, so "healthy, uptime" names two rules
+func splitList(value string) []string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// Checks that the configuration can be run, and reports every problem found
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(!strings.ContainsAny(c.ID, " \t\n"), "ID must not contain whitespace, got %q", c.ID)
+	check(c.ElevatorPort > 0 && c.ElevatorPort < 65536, "ElevatorPort must be between 1 and 65535, got %d", c.ElevatorPort)
+	if c.HTTPAddr != "" {
+		_, port, err := net.SplitHostPort(c.HTTPAddr)
+		check(err == nil && port != "", "HTTPAddr must be host:port or :port, got %q", c.HTTPAddr)
+	}
+	check(c.AssignmentMode == MasterAssignment || c.AssignmentMode == PeerAssignment, "AssignmentMode must be master or peer, got %q", c.AssignmentMode)
+	check(c.NumFloors >= 2 && c.NumFloors <= 255, "NumFloors must be between 2 and 255, got %d", c.NumFloors) // Floors are single bytes in the elevio protocol
+	check(c.DoorOpenTime.Duration > 0, "DoorOpenTime must be positive")
+	check(c.NotMovingTimeLimit.Duration > 0, "NotMovingTimeLimit must be positive")
+	check(c.ObstructionTimeLimit.Duration > 0, "ObstructionTimeLimit must be positive")
+
+	ports := map[string]int{
+		"BroadcastPort":   c.Network.BroadcastPort,
+		"PeerPort":        c.Network.PeerPort,
+		"AssignmentPort":  c.Network.AssignmentPort,
+		"RawHallCallPort": c.Network.RawHallCallPort,
+		"AckPort":         c.Network.AckPort,
+		"StatusPort":      c.Network.StatusPort,
+		"LightPort":       c.Network.LightPort,
+		"ElectionPort":    c.Network.ElectionPort,
+	}
+	usedBy := make(map[int]string)
+	for _, name := range []string{"BroadcastPort", "PeerPort", "AssignmentPort", "RawHallCallPort", "AckPort", "StatusPort", "LightPort", "ElectionPort"} {
+		port := ports[name]
+		check(port > 0 && port < 65536, "Network.%s must be between 1 and 65535, got %d", name, port)
+		if other, exists := usedBy[port]; exists {
+			check(false, "Network.%s and Network.%s both use port %d", other, name, port)
+		}
+		usedBy[port] = name
+	}
+	check(c.Network.PeerInterval.Duration > 0, "Network.PeerInterval must be positive")
+	check(c.Network.PeerTimeout.Duration > c.Network.PeerInterval.Duration, "Network.PeerTimeout must be longer than Network.PeerInterval")
+	check(c.Network.MasterHeartbeat.Duration > 0, "Network.MasterHeartbeat must be positive")
+	check(c.Network.MasterTimeout.Duration > c.Network.MasterHeartbeat.Duration, "Network.MasterTimeout must be longer than Network.MasterHeartbeat")
+	knownCodec := false
+	for _, name := range WireCodecs {
+		knownCodec = knownCodec || c.Network.Codec == name
+	}
+	check(knownCodec, "Network.Codec must be one of %s, got %q", strings.Join(WireCodecs, ", "), c.Network.Codec)
+	check(c.Network.Codec != "binary" || c.Network.ProtocolVersion >= 3, "Network.Codec binary needs Network.ProtocolVersion 3 or newer, got %d", c.Network.ProtocolVersion)
+	check(c.Network.MaxMessageSize >= 1024, "Network.MaxMessageSize must be at least the datagram size of 1024 bytes, got %d", c.Network.MaxMessageSize)
+	check(c.Network.FragmentTimeout.Duration > 0, "Network.FragmentTimeout must be positive")
+	knownAuthMode := false
+	for _, mode := range AuthModes {
+		knownAuthMode = knownAuthMode || c.Network.AuthMode == mode
+	}
+	check(knownAuthMode, "Network.AuthMode must be one of %s, got %q", strings.Join(AuthModes, ", "), c.Network.AuthMode)
+	if c.Network.AuthMode != "off" {
+		check(len(c.Network.AuthKey) >= 16, "Network.AuthMode %s needs a key of at least 16 bytes in Network.AuthKeyFile, got %d bytes", c.Network.AuthMode, len(c.Network.AuthKey))
+		check(c.Network.ProtocolVersion >= 5, "Network.AuthMode %s needs Network.ProtocolVersion 5 or newer, got %d", c.Network.AuthMode, c.Network.ProtocolVersion)
+	}
+	check(c.Network.ReplayWindow.Duration > 0, "Network.ReplayWindow must be positive")
+	check(c.Network.ProtocolVersion >= LegacyProtocol && c.Network.ProtocolVersion <= CurrentProtocol, "Network.ProtocolVersion must be between %d and %d, got %d", LegacyProtocol, CurrentProtocol, c.Network.ProtocolVersion)
+
+	check(len(c.Election.Policy) > 0, "Election.Policy must name at least one rule")
+	seenRules := make(map[string]bool)
+	for _, rule := range c.Election.Policy {
+		known := false
+		for _, name := range ElectionRules {
+			known = known || rule == name
+		}
+		check(known, "Election.Policy rules must be one of %s, got %q", strings.Join(ElectionRules, ", "), rule)
+		check(!seenRules[rule], "Election.Policy names %q more than once", rule)
+		seenRules[rule] = true
+	}
+
+	check(c.Retry.MaxRetries >= 1, "Retry.MaxRetries must be at least 1, got %d", c.Retry.MaxRetries)
+	check(c.Retry.RetryInterval.Duration > 0, "Retry.RetryInterval must be positive")
+	check(c.Retry.ExponentialBackoff >= 1, "Retry.ExponentialBackoff must be at least 1, got %d", c.Retry.ExponentialBackoff)
+	check(c.Retry.RedundancyFactor >= 1, "Retry.RedundancyFactor must be at least 1, got %d", c.Retry.RedundancyFactor)
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "Log.Level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	check(c.Log.Format == "text" || c.Log.Format == "json", "Log.Format must be text or json, got %q", c.Log.Format)
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package config
+
+import "testing"
+
+func TestExampleConfigLoads(t *testing.T) {
+	t.Setenv("ELEVATOR_CONFIG", "")
+	cfg, err := Load([]string{"-config", "../config.example.json"})
+	if err != nil {
+		t.Fatalf("config.example.json: %v", err)
+	}
+	if cfg.Network.ProtocolVersion != CurrentProtocol {
+		t.Errorf("config.example.json sends version %d, want the current version %d", cfg.Network.ProtocolVersion, CurrentProtocol)
+	}
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default configuration: %v", err)
+	}
+}
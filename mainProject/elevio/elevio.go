@@ -1,18 +1,14 @@
 package elevio
 
-import "time"
-import "sync"
-import "net"
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 
 
 const _pollRate = 20 * time.Millisecond
 
-var _initialized    bool = false
-var _numFloors      int = 4
-var _mtx            sync.Mutex
-var _conn           net.Conn
 
 type MotorDirection int
 
@@ -30,60 +26,62 @@ const (
 	BT_Cab                 = 2
 )
 
-type ButtonEvent struct {
-	Floor  int
-	Button ButtonType
+func (md MotorDirection) String() string {
+	switch md {
+	case MD_Up:
+		return "up"
+	case MD_Down:
+		return "down"
+	case MD_Stop:
+		return "stop"
+	}
+	return fmt.Sprintf("MotorDirection(%d)", int(md))
 }
 
-
-
-func Init(addr string, numFloors int) {
-	if _initialized {
-		fmt.Println("Driver already initialized!")
-		return
+func (b ButtonType) String() string {
+	switch b {
+	case BT_HallUp:
+		return "hall_up"
+	case BT_HallDown:
+		return "hall_down"
+	case BT_Cab:
+		return "cab"
 	}
-	_numFloors = numFloors
-	_mtx = sync.Mutex{}
-	var err error
-	_conn, err = net.Dial("tcp", addr)
-	if err != nil {
-		panic("Failed to connect to simulator: " + err.Error())
-	}
-	fmt.Println("Successfully connected to simulator at", addr)
-	_initialized = true
+	return fmt.Sprintf("ButtonType(%d)", int(b))
 }
 
-
-
-func SetMotorDirection(dir MotorDirection) {
-	write([4]byte{1, byte(dir), 0, 0})
+type ButtonEvent struct {
+	Floor  int
+	Button ButtonType
 }
 
-func SetButtonLamp(button ButtonType, floor int, value bool) {
-	write([4]byte{2, byte(button), byte(floor), toByte(value)})
-}
 
-func SetFloorIndicator(floor int) {
-	write([4]byte{3, byte(floor), 0, 0})
-}
 
-func SetDoorOpenLamp(value bool) {
-	write([4]byte{4, toByte(value), 0, 0})
-}
+// Driver is the hardware interface used by the elevator controller. The TCP
+// simulator protocol is one implementation, but anything that can drive the
+// motor, read the sensors and switch the lamps can be plugged in.
+type Driver interface {
+	SetMotorDirection(dir MotorDirection)
+	SetButtonLamp(button ButtonType, floor int, value bool)
+	SetFloorIndicator(floor int)
+	SetDoorOpenLamp(value bool)
+	SetStopLamp(value bool)
 
-func SetStopLamp(value bool) {
-	write([4]byte{5, toByte(value), 0, 0})
+	GetButton(button ButtonType, floor int) bool
+	GetFloor() int
+	GetStop() bool
+	GetObstruction() bool
 }
 
 
 
-func PollButtons(receiver chan<- ButtonEvent) {
-	prev := make([][3]bool, _numFloors)
+func PollButtons(driver Driver, numFloors int, receiver chan<- ButtonEvent) {
+	prev := make([][3]bool, numFloors)
 	for {
 		time.Sleep(_pollRate)
-		for f := 0; f < _numFloors; f++ {
+		for f := 0; f < numFloors; f++ {
 			for b := ButtonType(0); b < 3; b++ {
-				v := GetButton(b, f)
+				v := driver.GetButton(b, f)
 				if v != prev[f][b] && v != false {
 					receiver <- ButtonEvent{f, ButtonType(b)}
 				}
@@ -93,11 +91,11 @@ func PollButtons(receiver chan<- ButtonEvent) {
 	}
 }
 
-func PollFloorSensor(receiver chan<- int) {
-	prev := GetFloor()
+func PollFloorSensor(driver Driver, receiver chan<- int) {
+	prev := driver.GetFloor()
 	for {
 		time.Sleep(_pollRate)
-		v := GetFloor()
+		v := driver.GetFloor()
 		if v != prev && v != -1 {
 			receiver <- v
 		}
@@ -105,11 +103,11 @@ func PollFloorSensor(receiver chan<- int) {
 	}
 }
 
-func PollStopButton(receiver chan<- bool) {
+func PollStopButton(driver Driver, receiver chan<- bool) {
 	prev := false
 	for {
 		time.Sleep(_pollRate)
-		v := GetStop()
+		v := driver.GetStop()
 		if v != prev {
 			receiver <- v
 		}
@@ -117,11 +115,11 @@ func PollStopButton(receiver chan<- bool) {
 	}
 }
 
-func PollObstructionSwitch(receiver chan<- bool) {
+func PollObstructionSwitch(driver Driver, receiver chan<- bool) {
 	prev := false
 	for {
 		time.Sleep(_pollRate)
-		v := GetObstruction()
+		v := driver.GetObstruction()
 		if v != prev {
 			receiver <- v
 		}
@@ -132,57 +130,6 @@ func PollObstructionSwitch(receiver chan<- bool) {
 
 
 
-func GetButton(button ButtonType, floor int) bool {
-	a := read([4]byte{6, byte(button), byte(floor), 0})
-	return toBool(a[1])
-}
-
-func GetFloor() int {
-	a := read([4]byte{7, 0, 0, 0})
-	if a[1] != 0 {
-		return int(a[2])
-	} else {
-		return -1
-	}
-}
-
-func GetStop() bool {
-	a := read([4]byte{8, 0, 0, 0})
-	return toBool(a[1])
-}
-
-func GetObstruction() bool {
-	a := read([4]byte{9, 0, 0, 0})
-	return toBool(a[1])
-}
-
-
-
-
-
-func read(in [4]byte) [4]byte {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	
-	_, err := _conn.Write(in[:])
-	if err != nil { panic("Lost connection to Elevator Server") }
-	
-	var out [4]byte
-	_, err = _conn.Read(out[:])
-	if err != nil { panic("Lost connection to Elevator Server") }
-	
-	return out
-}
-
-func write(in [4]byte) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	
-	_, err := _conn.Write(in[:])
-	if err != nil { panic("Lost connection to Elevator Server") }
-}
-
-
 func toByte(a bool) byte {
 	var b byte = 0
 	if a {
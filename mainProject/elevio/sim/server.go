@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"mainProject/elevio"
+	"net"
+)
+
+// Listens on `addr` and serves the simulator to every client that connects,
+// using the same protocol as the external elevatorserver
+func (s *Simulator) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Simulator listening on", listener.Addr())
+	return s.Serve(listener)
+}
+
+func (s *Simulator) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Simulator client connected from", conn.RemoteAddr())
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Simulator) handleConn(conn net.Conn) {
+	defer conn.Close()
+	var in [4]byte
+	for {
+		if _, err := io.ReadFull(conn, in[:]); err != nil {
+			fmt.Println("Simulator client disconnected:", conn.RemoteAddr())
+			return
+		}
+		out, reply := s.handleCommand(in)
+		if !reply {
+			continue
+		}
+		if _, err := conn.Write(out[:]); err != nil {
+			fmt.Println("Simulator client disconnected:", conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// Executes one protocol command. Only the read commands (6-9) are answered.
+func (s *Simulator) handleCommand(in [4]byte) ([4]byte, bool) {
+	switch in[0] {
+	case 1:
+		s.SetMotorDirection(elevio.MotorDirection(int8(in[1])))
+	case 2:
+		s.SetButtonLamp(elevio.ButtonType(in[1]), int(in[2]), in[3] != 0)
+	case 3:
+		s.SetFloorIndicator(int(in[1]))
+	case 4:
+		s.SetDoorOpenLamp(in[1] != 0)
+	case 5:
+		s.SetStopLamp(in[1] != 0)
+	case 6:
+		return [4]byte{6, toByte(s.GetButton(elevio.ButtonType(in[1]), int(in[2]))), 0, 0}, true
+	case 7:
+		floor := s.GetFloor()
+		if floor == -1 {
+			return [4]byte{7, 0, 0, 0}, true
+		}
+		return [4]byte{7, 1, byte(floor), 0}, true
+	case 8:
+		return [4]byte{8, toByte(s.GetStop()), 0, 0}, true
+	case 9:
+		return [4]byte{9, toByte(s.GetObstruction()), 0, 0}, true
+	}
+	return [4]byte{}, false
+}
+
+func toByte(a bool) byte {
+	if a {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,212 @@
+package sim
+
+import (
+	"mainProject/elevio"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTravelTime = 2 * time.Second // Time to travel between two adjacent floors
+	sensorWidth       = 0.05            // Part of a floor (on each side) where the floor sensor is active
+	pressDuration     = 100 * time.Millisecond
+)
+
+// Simulator models a single elevator car: position between floors, travel
+// time, floor sensor edges, lamps and the obstruction and stop switches.
+// It implements elevio.Driver for in-process use, and can be served over TCP
+// with the same 4-byte protocol as the external elevatorserver.
+type Simulator struct {
+	mtx        sync.Mutex
+	numFloors  int
+	travelTime time.Duration
+
+	position   float64 // Car position in floors, 0 is the bottom floor
+	direction  elevio.MotorDirection
+	lastUpdate time.Time
+
+	buttons        [][3]bool
+	buttonLamps    [][3]bool
+	floorIndicator int
+	doorLamp       bool
+	stopLamp       bool
+	obstruction    bool
+	stop           bool
+}
+
+// Snapshot of the simulated hardware, used for printing and assertions
+type State struct {
+	Position       float64
+	Floor          int
+	Direction      elevio.MotorDirection
+	ButtonLamps    [][3]bool
+	FloorIndicator int
+	DoorLamp       bool
+	StopLamp       bool
+	Obstruction    bool
+	Stop           bool
+}
+
+// Creates a simulator with the car resting at `startFloor`
+func New(numFloors int, startFloor int, travelTime time.Duration) *Simulator {
+	if travelTime <= 0 {
+		travelTime = DefaultTravelTime
+	}
+	return &Simulator{
+		numFloors:   numFloors,
+		travelTime:  travelTime,
+		position:    float64(startFloor),
+		direction:   elevio.MD_Stop,
+		lastUpdate:  time.Now(),
+		buttons:     make([][3]bool, numFloors),
+		buttonLamps: make([][3]bool, numFloors),
+	}
+}
+
+// Moves the car according to the motor direction and the time since the last update.
+// Must be called with the mutex held.
+func (s *Simulator) update() {
+	now := time.Now()
+	elapsed := now.Sub(s.lastUpdate)
+	s.lastUpdate = now
+
+	s.position += float64(s.direction) * float64(elapsed) / float64(s.travelTime)
+	// The car stops at the end of the shaft
+	s.position = math.Max(0, math.Min(s.position, float64(s.numFloors-1)))
+}
+
+// Returns the floor the car is at, or -1 if it is between floors. Must be called with the mutex held.
+func (s *Simulator) floor() int {
+	nearest := math.Round(s.position)
+	if math.Abs(s.position-nearest) <= sensorWidth {
+		return int(nearest)
+	}
+	return -1
+}
+
+func (s *Simulator) validButton(button elevio.ButtonType, floor int) bool {
+	return floor >= 0 && floor < s.numFloors && button >= 0 && button < 3
+}
+
+// -----------------------------------------------------------------------------
+// elevio.Driver
+// -----------------------------------------------------------------------------
+func (s *Simulator) SetMotorDirection(dir elevio.MotorDirection) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.update()
+	if s.stop {
+		dir = elevio.MD_Stop // The stop switch cuts motor power
+	}
+	s.direction = dir
+}
+
+func (s *Simulator) SetButtonLamp(button elevio.ButtonType, floor int, value bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.validButton(button, floor) {
+		s.buttonLamps[floor][button] = value
+	}
+}
+
+func (s *Simulator) SetFloorIndicator(floor int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if floor >= 0 && floor < s.numFloors {
+		s.floorIndicator = floor
+	}
+}
+
+func (s *Simulator) SetDoorOpenLamp(value bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.doorLamp = value
+}
+
+func (s *Simulator) SetStopLamp(value bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stopLamp = value
+}
+
+func (s *Simulator) GetButton(button elevio.ButtonType, floor int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.validButton(button, floor) && s.buttons[floor][button]
+}
+
+func (s *Simulator) GetFloor() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.update()
+	return s.floor()
+}
+
+func (s *Simulator) GetStop() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.stop
+}
+
+func (s *Simulator) GetObstruction() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.obstruction
+}
+
+// -----------------------------------------------------------------------------
+// Controls for tests and the interactive simulator
+// -----------------------------------------------------------------------------
+// Holds a button down or releases it
+func (s *Simulator) SetButton(button elevio.ButtonType, floor int, pressed bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.validButton(button, floor) {
+		s.buttons[floor][button] = pressed
+	}
+}
+
+// Presses a button long enough for a polling driver to notice, then releases it
+func (s *Simulator) PressButton(button elevio.ButtonType, floor int) {
+	s.SetButton(button, floor, true)
+	time.AfterFunc(pressDuration, func() {
+		s.SetButton(button, floor, false)
+	})
+}
+
+func (s *Simulator) SetObstruction(obstructed bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.obstruction = obstructed
+}
+
+func (s *Simulator) SetStop(pressed bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.update()
+	s.stop = pressed
+	if pressed {
+		s.direction = elevio.MD_Stop
+	}
+}
+
+func (s *Simulator) State() State {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.update()
+
+	lamps := make([][3]bool, s.numFloors)
+	copy(lamps, s.buttonLamps)
+	return State{
+		Position:       s.position,
+		Floor:          s.floor(),
+		Direction:      s.direction,
+		ButtonLamps:    lamps,
+		FloorIndicator: s.floorIndicator,
+		DoorLamp:       s.doorLamp,
+		StopLamp:       s.stopLamp,
+		Obstruction:    s.obstruction,
+		Stop:           s.stop,
+	}
+}
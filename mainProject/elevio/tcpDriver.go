@@ -0,0 +1,95 @@
+package elevio
+
+import (
+	"net"
+	"sync"
+)
+
+// TCPDriver talks to an elevator server (the simulator or the hardware
+// bridge) using the 4-byte command protocol.
+type TCPDriver struct {
+	mtx  sync.Mutex
+	conn net.Conn
+}
+
+// Connects to the elevator server at `addr`. Panics if the server is unreachable,
+// as the elevator cannot run without it.
+func NewTCPDriver(addr string) *TCPDriver {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic("Failed to connect to simulator: " + err.Error())
+	}
+	return &TCPDriver{conn: conn}
+}
+
+
+
+func (d *TCPDriver) SetMotorDirection(dir MotorDirection) {
+	d.write([4]byte{1, byte(dir), 0, 0})
+}
+
+func (d *TCPDriver) SetButtonLamp(button ButtonType, floor int, value bool) {
+	d.write([4]byte{2, byte(button), byte(floor), toByte(value)})
+}
+
+func (d *TCPDriver) SetFloorIndicator(floor int) {
+	d.write([4]byte{3, byte(floor), 0, 0})
+}
+
+func (d *TCPDriver) SetDoorOpenLamp(value bool) {
+	d.write([4]byte{4, toByte(value), 0, 0})
+}
+
+func (d *TCPDriver) SetStopLamp(value bool) {
+	d.write([4]byte{5, toByte(value), 0, 0})
+}
+
+
+
+func (d *TCPDriver) GetButton(button ButtonType, floor int) bool {
+	a := d.read([4]byte{6, byte(button), byte(floor), 0})
+	return toBool(a[1])
+}
+
+func (d *TCPDriver) GetFloor() int {
+	a := d.read([4]byte{7, 0, 0, 0})
+	if a[1] != 0 {
+		return int(a[2])
+	} else {
+		return -1
+	}
+}
+
+func (d *TCPDriver) GetStop() bool {
+	a := d.read([4]byte{8, 0, 0, 0})
+	return toBool(a[1])
+}
+
+func (d *TCPDriver) GetObstruction() bool {
+	a := d.read([4]byte{9, 0, 0, 0})
+	return toBool(a[1])
+}
+
+
+
+func (d *TCPDriver) read(in [4]byte) [4]byte {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	_, err := d.conn.Write(in[:])
+	if err != nil { panic("Lost connection to Elevator Server") }
+
+	var out [4]byte
+	_, err = d.conn.Read(out[:])
+	if err != nil { panic("Lost connection to Elevator Server") }
+
+	return out
+}
+
+func (d *TCPDriver) write(in [4]byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	_, err := d.conn.Write(in[:])
+	if err != nil { panic("Lost connection to Elevator Server") }
+}
@@ -0,0 +1,219 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mainProject/elevio"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Order lifecycle event journal
+// -----------------------------------------------------------------------------
+// Every node can append the events that change order state to a JSON-lines file.
+// The replay command merges the journals of several nodes into a timeline of
+// each hall call. Recording is a no-op until Open is called.
+
+type Kind string
+
+const (
+	ButtonPressed       Kind = "button_pressed"
+	RawHallCallSent     Kind = "raw_hall_call_sent"
+	RawHallCallReceived Kind = "raw_hall_call_received"
+	HallCallAssigned    Kind = "hall_call_assigned"
+	HallCallUnassigned  Kind = "hall_call_unassigned" // No elevator available, kept by the master
+	HallCallReassigned  Kind = "hall_call_reassigned" // The assigned elevator did not take the call, so the master gave it to another
+	AssignmentReceived  Kind = "assignment_received"
+	HallCallHandedBack  Kind = "hall_call_handed_back"
+	HallCallRevoked     Kind = "hall_call_revoked" // Taken from an elevator after a split brain, as another elevator serves it
+	OrderStatusSent     Kind = "order_status_sent"
+	OrderStatusReceived Kind = "order_status_received"
+	LightOrderSent      Kind = "light_order_sent"     // Only in journals of older versions, which sent lamps with light orders
+	LightOrderReceived  Kind = "light_order_received" // Only in journals of older versions
+	HallCallServed      Kind = "hall_call_served"     // Door opened at the floor of the call
+	MessageAcked        Kind = "message_acked"
+	MessageFailed       Kind = "message_failed"
+	PeerNew             Kind = "peer_new"
+	PeerLost            Kind = "peer_lost"
+	MasterChanged       Kind = "master_changed"
+	MasterHandedOff     Kind = "master_handed_off"    // This master shut down and handed over to the new one
+	SplitBrainDetected  Kind = "split_brain_detected" // Another master was seen while this node was master
+	WorldViewsMerged    Kind = "world_views_merged"   // Hall calls reconciled after peers joined
+	HallOrderConfirmed  Kind = "hall_order_confirmed" // Every elevator has seen the hall call, and its lamp is lit
+)
+
+type Event struct {
+	Node    string        // Elevator that recorded the event
+	Started time.Time     // When the node opened the journal. Seq and Mono restart with every run
+	Seq     int           // Position in the run
+	Time    time.Time     // Wall clock, used to merge journals from several nodes
+	Mono    time.Duration // Monotonic time since the journal was opened, for ordering and latency within a node
+	Kind    Kind
+	OrderID string              `json:",omitempty"`
+	Call    *elevio.ButtonEvent `json:",omitempty"`
+	Peer    string              `json:",omitempty"` // Other elevator involved: target, sender, new or lost peer, or master
+	Message string              `json:",omitempty"` // Message type for acks and failures
+	SeqNum  int                 `json:",omitempty"` // Sequence number of the message
+	Detail  string              `json:",omitempty"` // E.g. unfinished/finished or on/off
+}
+
+var (
+	journalMutex sync.Mutex
+	file         *os.File
+	writer       *bufio.Writer
+	node         string
+	openedAt     time.Time
+	nextSeq      int
+)
+
+// Starts appending events for `nodeID` to the file at `path`
+func Open(path string, nodeID string) error {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open journal: %w", err)
+	}
+	file = f
+	writer = bufio.NewWriter(f)
+	node = nodeID
+	openedAt = time.Now()
+	nextSeq = 0
+	go flushPeriodically(f)
+	return nil
+}
+
+// Events that come with a warning are written at once, as the process may be
+// about to crash and they are the ones a post-mortem needs
+var flushedKinds = map[Kind]bool{
+	HallCallUnassigned: true,
+	HallCallReassigned: true,
+	MessageFailed:      true,
+	PeerLost:           true,
+	SplitBrainDetected: true,
+}
+
+// Events are buffered, and flushed to the file at least once a second
+func flushPeriodically(f *os.File) {
+	for {
+		time.Sleep(time.Second)
+		journalMutex.Lock()
+		if file != f {
+			journalMutex.Unlock()
+			return
+		}
+		writer.Flush()
+		journalMutex.Unlock()
+	}
+}
+
+// Writes the buffered events to the file. Called when a warning is logged.
+func Flush() {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	if file == nil {
+		return
+	}
+	writer.Flush()
+}
+
+func Close() error {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	if file == nil {
+		return nil
+	}
+	writer.Flush()
+	err := file.Close()
+	file = nil
+	return err
+}
+
+// Appends an event, filling in the node, sequence number and timestamps
+func Record(e Event) {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	if file == nil {
+		return
+	}
+	now := time.Now()
+	e.Node = node
+	e.Started = openedAt
+	e.Seq = nextSeq
+	e.Time = now
+	e.Mono = now.Sub(openedAt) // Uses the monotonic clock reading
+	nextSeq++
+	data, _ := json.Marshal(e)
+	writer.Write(data)
+	writer.WriteByte('\n')
+	if flushedKinds[e.Kind] {
+		writer.Flush()
+	}
+}
+
+// Records an event about a call
+func RecordCall(kind Kind, call elevio.ButtonEvent, orderID string, peer string) {
+	Record(Event{Kind: kind, Call: &call, OrderID: orderID, Peer: peer})
+}
+
+// Reads the events of one or more journals, merged by wall clock time.
+// Events from the same run of a node keep their recorded order, even if the wall clock jumped.
+func Read(readers ...io.Reader) ([]Event, error) {
+	type runKey struct {
+		node    string
+		started time.Time
+	}
+	runs := make(map[runKey][]Event)
+	var order []runKey
+	for _, r := range readers {
+		scanner := bufio.NewScanner(r)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			key := runKey{e.Node, e.Started}
+			if _, exists := runs[key]; !exists {
+				order = append(order, key)
+			}
+			runs[key] = append(runs[key], e)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range order {
+		run := runs[key]
+		sort.SliceStable(run, func(i, j int) bool { return run[i].Seq < run[j].Seq })
+	}
+
+	// Merge the runs, always taking the earliest of their next events
+	var events []Event
+	for {
+		next := -1
+		for i, key := range order {
+			run := runs[key]
+			if len(run) == 0 {
+				continue
+			}
+			if next == -1 || run[0].Time.Before(runs[order[next]][0].Time) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return events, nil
+		}
+		events = append(events, runs[order[next]][0])
+		runs[order[next]] = runs[order[next]][1:]
+	}
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// -----------------------------------------------------------------------------
+// Structured, leveled logging
+// -----------------------------------------------------------------------------
+// Every module gets its own logger from For, which adds a module field. The
+// loggers can be created before Setup runs, as they look up the output when
+// a record is written. Setup adds the local elevator ID to every record.
+
+var (
+	current   atomic.Pointer[slog.Handler]
+	onWarning atomic.Pointer[func()]
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
+	current.Store(&h)
+}
+
+// Directs all loggers to `w` with the given minimum level and format ("text" or "json")
+func Setup(w io.Writer, elevatorID string, level string, format string) error {
+	parsedLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: parsedLevel}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, options)
+	case "json":
+		h = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("log format must be \"text\" or \"json\", got %q", format)
+	}
+	h = h.WithAttrs([]slog.Attr{slog.String("elevator", elevatorID)})
+	current.Store(&h)
+	return nil
+}
+
+// Calls `f` for every record of level Warn or above, also when the level is
+// not logged. Used to flush the event journal before a possible crash.
+func OnWarning(f func()) {
+	onWarning.Store(&f)
+}
+
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("log level must be debug, info, warn or error, got %q", level)
+}
+
+// Returns the logger for a module
+func For(module string) *slog.Logger {
+	return slog.New(moduleHandler{}).With("module", module)
+}
+
+// Forwards records to the handler installed by Setup, applying the attributes
+// and groups added to the logger on the way
+type moduleHandler struct {
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h moduleHandler) handler() slog.Handler {
+	handler := *current.Load()
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler
+}
+
+func (h moduleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelWarn && onWarning.Load() != nil {
+		return true
+	}
+	return (*current.Load()).Enabled(ctx, level)
+}
+
+func (h moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if f := onWarning.Load(); f != nil && r.Level >= slog.LevelWarn {
+		defer (*f)() // After the record is written, so the log and the journal agree
+	}
+	handler := h.handler()
+	if !handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h moduleHandler) with(wrap func(slog.Handler) slog.Handler) moduleHandler {
+	return moduleHandler{wrap: append(append([]func(slog.Handler) slog.Handler{}, h.wrap...), wrap)}
+}
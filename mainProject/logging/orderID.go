@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"fmt"
+	"mainProject/elevio"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Order correlation IDs
+// -----------------------------------------------------------------------------
+// A hall call gets an order ID when its button is pressed. The ID travels with the
+// raw hall call, the assignment and the order status, and is
+// logged as the "order" field, so one call can be followed across all elevators.
+// The counter restarts with every run, so the ID also names the run by its start
+// time. Otherwise the calls of several runs in one journal would share IDs.
+
+var (
+	orderIDs      = make(map[elevio.ButtonEvent]string)
+	orderCounter  int
+	orderIDsMutex sync.Mutex
+	incarnation   = strconv.FormatInt(time.Now().UnixMilli(), 36)
+)
+
+// Gives a newly pressed hall call an order ID, unless it already has one
+func NewOrderID(call elevio.ButtonEvent, elevatorID string) string {
+	orderIDsMutex.Lock()
+	defer orderIDsMutex.Unlock()
+	if id, exists := orderIDs[call]; exists {
+		return id
+	}
+	orderCounter++
+	id := fmt.Sprintf("%s-%s-%d", elevatorID, incarnation, orderCounter)
+	orderIDs[call] = id
+	return id
+}
+
+// Records the order ID received with a message. Empty IDs are ignored.
+func SetOrderID(call elevio.ButtonEvent, id string) {
+	if id == "" {
+		return
+	}
+	orderIDsMutex.Lock()
+	defer orderIDsMutex.Unlock()
+	orderIDs[call] = id
+}
+
+// Returns the order ID of a hall call, or "" if it has none
+func OrderID(call elevio.ButtonEvent) string {
+	orderIDsMutex.Lock()
+	defer orderIDsMutex.Unlock()
+	return orderIDs[call]
+}
+
+// Forgets the order ID of a hall call that has been served
+func ForgetOrderID(call elevio.ButtonEvent) {
+	orderIDsMutex.Lock()
+	defer orderIDsMutex.Unlock()
+	delete(orderIDs, call)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"mainProject/api"
 	"mainProject/elevio"
 	"mainProject/config"
 	"mainProject/singleElevator"
@@ -9,15 +10,32 @@ import (
 	"mainProject/masterElection"
 	"mainProject/peerMonitor"
 	"mainProject/orderAssignment"
+	"mainProject/logging"
+	"mainProject/journal"
+	"mainProject/shutdown"
+	"fmt"
+	"os"
 )
 
 func main() {
 	config.InitConfig()
+	if err := logging.Setup(os.Stdout, config.LocalID, config.Cfg.Log.Level, config.Cfg.Log.Format); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	logging.For("main").Info("Starting elevator", "port", config.Cfg.ElevatorPort)
+	if config.Cfg.JournalFile != "" {
+		if err := journal.Open(config.Cfg.JournalFile, config.LocalID); err != nil {
+			logging.For("main").Error("Running without an event journal", "err", err)
+		} else {
+			logging.OnWarning(journal.Flush)
+		}
+	}
 
 	peerUpdatesChan 	  := make(chan peers.PeerUpdate)
 	localStatusUpdateChan := make(chan config.Elevator, 1)
 	elevatorStatusesChan  := make(chan map[string]communication.ElevatorStatus) 
-	masterElectionChan    := make(chan string, 1)            
+	masterElectionChan    := make(chan string, 10)            
 	lostPeerChan 		  := make(chan string)				
 	newPeerChan           := make(chan string)				
 	hallCallChan          := make(chan elevio.ButtonEvent, 20)  // Send hall calls to order_assignment
@@ -25,7 +43,9 @@ func main() {
 	assignedHallCallChan  := make(chan elevio.ButtonEvent, 20) // Receive assigned hall calls
 	txAckChan			  := make(chan communication.AckMessage, 20)
 
-	singleElevator.InitElevator(localStatusUpdateChan)
+	driver := elevio.NewTCPDriver(config.ElevatorAddr)
+	logging.For("main").Info("Connected to elevator server", "addr", config.ElevatorAddr)
+	singleElevator.InitElevator(driver, localStatusUpdateChan)
 
 	// Start single_elevator
 	go singleElevator.RunSingleElevator(hallCallChan, assignedHallCallChan, orderStatusChan, txAckChan, localStatusUpdateChan)
@@ -33,8 +53,10 @@ func main() {
 	// Start Peer Monitoring
 	go peerMonitor.RunMonitorPeers(peerUpdatesChan, lostPeerChan, newPeerChan, localStatusUpdateChan)
 	
-	// Start Master Election 
-	go masterElection.RunMasterElection(elevatorStatusesChan, masterElectionChan)
+	// Start Master Election, unless the elevators assign hall calls without a master
+	if config.Cfg.AssignmentMode == config.MasterAssignment {
+		go masterElection.RunMasterElection(masterElectionChan)
+	}
 
 	// Start Network
 	go communication.RunCommunication(elevatorStatusesChan, peerUpdatesChan, orderStatusChan, txAckChan, localStatusUpdateChan)
@@ -42,6 +64,10 @@ func main() {
 	// Start Order Assignment
 	go orderAssignment.RunOrderAssignment(elevatorStatusesChan, masterElectionChan, lostPeerChan, newPeerChan, hallCallChan, assignedHallCallChan, orderStatusChan, txAckChan)
 
-	select{}
+	// Start HTTP status and control API
+	go api.RunAPI(config.Cfg.HTTPAddr)
+
+	// Hand over and exit on SIGTERM, SIGINT or a request from the API
+	shutdown.Run()
 
 }
@@ -1,33 +1,547 @@
 package masterElection
 
 import (
-	"mainProject/config"
-	"mainProject/communication"
+	"errors"
 	"fmt"
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/metrics"
+	"mainProject/network/bcast"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Term-based master election
+// -----------------------------------------------------------------------------
+// Every master rules for a term, a number that only grows. A candidate announces
+// the next term and becomes master once every peer has accepted it, or when the
+// peers that did not answer have had MasterTimeout to do so. The master keeps
+// announcing its term, and the term travels with its assignments and acks so receivers can reject messages from an older master.
+//
+// An elevator that lost its master first asks its peers in a pre-vote, and only
+// raises its term once none of them still hears a master. An elevator that is
+// cut off from the others thus does not depose a master they still follow. A
+// follower also rejects announcements from others while its master is alive,
+// whatever their term, unless its master handed over to them or follows them.
+//
+// A starting elevator listens for MasterTimeout before running, so it follows a
+// master that is already ruling instead of taking over, even if it would be
+// preferred. Among elevators without a master the one preferred by the selection
+// policy runs first (see policy.go). Two announcements for the same term are
+// settled in favour of the lowest ID, which needs no agreement on the statuses.
+//
+// When two masters meet, e.g. after a partition heals, the one followed by more
+// elevators stays, then the one with the newer term, then the lowest ID. A master
+// that hears of a term at least its own from an elevator it does not yield to
+// raises its term past it, so its followers and announcements are never rejected
+// as older.
+//
+// A master shutting down asks the peer preferred by the policy to run at once,
+// without a pre-vote, and never runs again itself, so the other elevators need
+// not wait for MasterTimeout.
+
+var log = logging.For("masterElection")
+
+type role int
+
+const (
+	follower     role = iota
+	preCandidate      // Asking the peers whether it may run
+	candidate
+	leader
 )
 
-// Runs Master Election and Listens for Updates
-func RunMasterElection(elevatorStateChan chan map[string]communication.ElevatorStatus, masterChan chan string) {
-	go func() {
-		for elevatorStates := range elevatorStateChan {
-			newMasterID := determineMaster(elevatorStates, config.LocalID)
-			if config.MasterID == newMasterID {
+type election struct {
+	role     role
+	term     int    // Highest term seen
+	masterID string // Master or candidate backed in `term`, "" if none
+
+	startedAt        time.Time
+	lastHeard        time.Time       // Last announcement from masterID
+	masterlessSince  time.Time       // Zero while there is a master
+	candidacyStarted time.Time       // Start of the pre-vote or the candidacy
+	granted          map[string]bool // Peers that granted our pre-vote
+	denied           bool            // A peer still hears a master, or knows a newer term
+	accepted         map[string]bool // Peers that accepted our candidacy
+	handoffTo        string          // Successor named by our master as it hands over
+
+	policy policy
+
+	leaving     bool        // Shutting down, so never runs for master
+	successor   string      // Peer asked to take over, while handing off
+	handoffDone chan string // Receives the next master once the handoff is done
+
+	tx         chan communication.ElectionMessage
+	masterChan chan string
+}
+
+var handoffRequests = make(chan chan string)
+
+// Hands mastership to another elevator before a planned shutdown, and returns the
+// new master. Returns at once with this elevator's master if it is not the master.
+// After a handoff has been requested this elevator never runs for master again.
+func HandOff(timeout time.Duration) (string, error) {
+	done := make(chan string, 1)
+	deadline := time.After(timeout)
+	select {
+	case handoffRequests <- done:
+	case <-deadline:
+		return "", errors.New("master election is not running")
+	}
+	select {
+	case newMaster := <-done:
+		if newMaster == "" {
+			return "", errors.New("no other elevator to hand over to")
+		}
+		return newMaster, nil
+	case <-deadline:
+		return "", fmt.Errorf("no new master within %s", timeout)
+	}
+}
+
+// Runs the election protocol. Every change of master is sent on `masterChan`, "" when the master is lost.
+func RunMasterElection(masterChan chan string) {
+	tx := make(chan communication.ElectionMessage, 50)
+	rx := make(chan communication.ElectionMessage, 50)
+	go bcast.Transmitter(config.Cfg.Network.ElectionPort, tx)
+	go bcast.Receiver(config.Cfg.Network.ElectionPort, rx)
+
+	selection, err := newPolicy(config.Cfg.Election.Policy)
+	if err != nil {
+		log.Error("Invalid election policy, electing the lowest ID", "err", err)
+		selection, _ = newPolicy(nil)
+	}
+
+	now := time.Now()
+	e := &election{
+		policy:          selection,
+		startedAt:       now,
+		masterlessSince: now,
+		tx:              tx,
+		masterChan:      masterChan,
+	}
+	ticker := time.NewTicker(config.Cfg.Network.MasterHeartbeat.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-rx:
+			e.handleMessage(msg, time.Now())
+		case done := <-handoffRequests:
+			e.startHandoff(done)
+		case now := <-ticker.C:
+			e.tick(now)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Periodic work: announcing, detecting a lost master and running for master
+// -----------------------------------------------------------------------------
+func (e *election) tick(now time.Time) {
+	timeout := config.Cfg.Network.MasterTimeout.Duration
+	peers := alivePeers()
+
+	switch e.role {
+	case leader:
+		e.announce()
+		if e.successor != "" {
+			e.send(communication.Handoff, e.successor)
+		}
+
+	case candidate:
+		var missing []string
+		for _, peer := range peers {
+			if peer != config.LocalID && !e.accepted[peer] {
+				missing = append(missing, peer)
+			}
+		}
+		if len(missing) == 0 {
+			e.becomeMaster(now)
+			return
+		}
+		if now.Sub(e.candidacyStarted) > timeout {
+			log.Warn("Taking over without every peer's acceptance", "term", e.term, "missing", missing)
+			e.becomeMaster(now)
+			return
+		}
+		e.announce()
+
+	case follower:
+		if e.masterID != "" && now.Sub(e.lastHeard) > timeout {
+			log.Warn("Lost the master", "master", e.masterID, "term", e.term)
+			e.masterID = ""
+			e.masterlessSince = now
+			e.setMaster("", e.term)
+		}
+		if e.masterID != "" || e.leaving || now.Sub(e.startedAt) < timeout {
+			return // Following a master, or still listening for one after starting
+		}
+		// The preferred elevator runs first. Others run if it has not taken over in time, e.g. if it cannot hear us.
+		if e.policy.best(candidates(peers)) == config.LocalID || now.Sub(e.masterlessSince) > 2*timeout {
+			e.startPreVote(now)
+		}
+
+	case preCandidate:
+		var missing []string
+		for _, peer := range peers {
+			if peer != config.LocalID && !e.granted[peer] {
+				missing = append(missing, peer)
+			}
+		}
+		if len(missing) == 0 && !e.denied {
+			e.runForMaster(now)
+			return
+		}
+		if now.Sub(e.candidacyStarted) > timeout {
+			if e.denied {
+				e.startPreVote(now) // Ask again, the master may since have been lost by the others too
+				return
+			}
+			log.Warn("Running for master without every peer's pre-vote", "term", e.term+1, "missing", missing)
+			e.runForMaster(now)
+			return
+		}
+		e.sendPreVote()
+	}
+}
+
+// Asks the peers whether this elevator may run for the next term, without raising the term
+func (e *election) startPreVote(now time.Time) {
+	e.role = preCandidate
+	e.granted = make(map[string]bool)
+	e.denied = false
+	e.candidacyStarted = now
+	log.Debug("Asking peers before running for master", "term", e.term+1)
+	e.sendPreVote()
+}
+
+func (e *election) runForMaster(now time.Time) {
+	e.role = candidate
+	e.term++
+	e.masterID = config.LocalID
+	e.accepted = make(map[string]bool)
+	e.candidacyStarted = now
+	log.Info("Running for master", "term", e.term)
+	e.announce()
+}
+
+func (e *election) becomeMaster(now time.Time) {
+	e.role = leader
+	e.lastHeard = now
+	e.setMaster(config.LocalID, e.term)
+	e.announce()
+}
+
+// -----------------------------------------------------------------------------
+// Election messages
+// -----------------------------------------------------------------------------
+func (e *election) handleMessage(msg communication.ElectionMessage, now time.Time) {
+	if msg.SenderID == config.LocalID {
+		return
+	}
+	if msg.Type == communication.Handoff && msg.SenderID == e.masterID && e.role == follower {
+		e.handoffTo = msg.TargetID // Followed once it announces, without waiting for the master to go quiet
+	}
+	if msg.TargetID != "" && msg.TargetID != config.LocalID {
+		return
+	}
+	if e.role == leader {
+		e.answerAsMaster(msg, now)
+		return
+	}
+
+	switch msg.Type {
+	case communication.PreVote:
+		if e.masterAlive(now) || e.role == candidate || msg.Term <= e.term {
+			log.Debug("Denying pre-vote", "candidate", msg.SenderID, "term", msg.Term, "current_term", e.term, "master", e.masterID)
+			e.send(communication.Reject, msg.SenderID)
+			return
+		}
+		e.tx <- communication.ElectionMessage{
+			Type:     communication.PreVoteGranted,
+			Term:     msg.Term,
+			SenderID: config.LocalID,
+			MasterID: msg.SenderID,
+			TargetID: msg.SenderID,
+		}
+
+	case communication.PreVoteGranted:
+		if e.role == preCandidate && msg.Term == e.term+1 {
+			e.granted[msg.SenderID] = true
+		}
+
+	case communication.Announce:
+		switch {
+		case msg.Term < e.term:
+			log.Debug("Rejecting announcement of an older term", "candidate", msg.MasterID, "term", msg.Term, "current_term", e.term)
+			e.send(communication.Reject, msg.SenderID)
+		case e.masterAlive(now) && msg.MasterID != e.masterID && !e.masterMovedTo(msg.MasterID):
+			log.Debug("Rejecting announcement while the master is alive", "candidate", msg.MasterID, "term", msg.Term, "master", e.masterID)
+			e.send(communication.Reject, msg.SenderID)
+		case msg.Term == e.term && e.masterID != "" && e.masterID != msg.MasterID && msg.MasterID > e.masterID:
+			log.Debug("Rejecting competing announcement", "candidate", msg.MasterID, "backing", e.masterID, "term", msg.Term)
+			e.send(communication.Reject, msg.SenderID)
+		default:
+			e.follow(msg.MasterID, msg.Term, now)
+			e.send(communication.Accept, msg.SenderID)
+		}
+
+	case communication.Handoff:
+		if msg.SenderID == e.masterID && msg.Term == e.term && e.role == follower && !e.leaving {
+			log.Info("Master is shutting down, taking over", "master", msg.SenderID, "term", msg.Term)
+			e.runForMaster(now)
+		}
+
+	case communication.Accept:
+		if e.role == candidate && msg.Term == e.term && msg.MasterID == config.LocalID {
+			e.accepted[msg.SenderID] = true
+		}
+
+	case communication.Reject:
+		if e.role == preCandidate {
+			if msg.Term < e.term {
+				e.denied = true // Its master, if any, raises its term past ours when it hears the pre-vote
 				return
 			}
-			config.MasterID = newMasterID
-			fmt.Printf("New Master Elected: %s\n\n", config.MasterID)
-			masterChan <- config.MasterID
+			if msg.MasterID != "" {
+				e.follow(msg.MasterID, msg.Term, now)
+				return
+			}
+			if msg.Term > e.term {
+				e.term = msg.Term
+				e.startPreVote(now) // Ask for the term after the one heard of
+			}
+			return
+		}
+		newerTerm := msg.Term > e.term
+		lowerCandidate := msg.Term == e.term && msg.MasterID != "" && msg.MasterID < e.masterID
+		if !newerTerm && !lowerCandidate {
+			return
+		}
+		if msg.MasterID == "" {
+			log.Info("Stepping down for a newer term without a master", "term", msg.Term)
+			e.role = follower
+			e.term = msg.Term
+			e.masterID = ""
+			e.masterlessSince = now
+			e.setMaster("", msg.Term)
+			return
+		}
+		e.follow(msg.MasterID, msg.Term, now)
+	}
+}
+
+// Answers a message received while this elevator is master
+func (e *election) answerAsMaster(msg communication.ElectionMessage, now time.Time) {
+	switch msg.Type {
+	case communication.PreVote:
+		if msg.Term > e.term {
+			e.raiseTerm(msg.Term)
+		}
+		e.send(communication.Reject, msg.SenderID)
+
+	case communication.Announce, communication.Reject:
+		if e.leaving && msg.Type == communication.Announce && msg.Term > e.term {
+			e.follow(msg.MasterID, msg.Term, now) // Our successor, or another elevator taking over
+			return
+		}
+		if msg.MasterID != msg.SenderID {
+			// A follower of another master, or an elevator without one
+			if msg.Term >= e.term {
+				e.raiseTerm(msg.Term)
+			}
+			return
+		}
+		rival := msg.SenderID
+		if e.ruling(rival) && e.yieldsTo(rival, msg.Term) {
+			if msg.Term > e.term {
+				log.Info("Yielding to a master followed by more elevators", "master", rival, "term", msg.Term)
+				e.follow(rival, msg.Term, now)
+			}
+			// Otherwise the rival raises its term past ours when it hears our announcement
+			return
+		}
+		if msg.Term >= e.term {
+			e.raiseTerm(msg.Term)
+		}
+		if !e.ruling(rival) {
+			e.send(communication.Reject, rival) // A candidate steps down at once, a master when it hears our announcement
+		}
+	}
+}
+
+// Backs `masterID` for `term`, stepping down if this elevator was running or ruling
+func (e *election) follow(masterID string, term int, now time.Time) {
+	if e.role != follower && masterID != config.LocalID {
+		log.Info("Stepping down", "master", masterID, "term", term)
+		e.role = follower
+	}
+	if masterID != e.masterID {
+		e.handoffTo = ""
+	}
+	e.term = term
+	e.masterID = masterID
+	e.lastHeard = now
+	e.masterlessSince = time.Time{}
+	e.setMaster(masterID, term)
+	if e.handoffDone != nil && masterID != config.LocalID {
+		journal.Record(journal.Event{Kind: journal.MasterHandedOff, Peer: masterID, Detail: fmt.Sprintf("term %d", term)})
+		e.handoffDone <- masterID
+		e.handoffDone = nil
+		e.successor = ""
+	}
+}
+
+func (e *election) startHandoff(done chan string) {
+	e.leaving = true
+	if e.role == candidate || e.role == preCandidate {
+		e.role = follower // Let another elevator win instead
+	}
+	if e.role != leader {
+		done <- config.MasterID()
+		return
+	}
+	var others []string
+	for _, peer := range alivePeers() {
+		if peer != config.LocalID {
+			others = append(others, peer)
+		}
+	}
+	if len(others) == 0 {
+		done <- ""
+		return
+	}
+	e.successor = e.policy.best(candidates(others))
+	e.handoffDone = done
+	log.Info("Handing mastership over", "successor", e.successor, "term", e.term)
+	e.send(communication.Handoff, e.successor)
+}
+
+// Raises the term of this master past `term`. It is announced on the next tick,
+// so two masters ranking each other differently raise their terms slowly until
+// the statuses agree.
+func (e *election) raiseTerm(term int) {
+	log.Info("Raising the term past one heard of", "term", term+1, "heard", term)
+	e.term = term + 1
+	e.setMaster(config.LocalID, e.term)
+}
+
+// Asks the peers whether this elevator may run for the next term
+func (e *election) sendPreVote() {
+	e.tx <- communication.ElectionMessage{
+		Type:     communication.PreVote,
+		Term:     e.term + 1,
+		SenderID: config.LocalID,
+		MasterID: config.LocalID,
+	}
+}
+
+// Announces this elevator's candidacy or mastership
+func (e *election) announce() {
+	e.tx <- communication.ElectionMessage{
+		Type:     communication.Announce,
+		Term:     e.term,
+		SenderID: config.LocalID,
+		MasterID: config.LocalID,
+	}
+}
+
+// Answers `target` with the term and master backed by this elevator
+func (e *election) send(msgType communication.ElectionMessageType, target string) {
+	e.tx <- communication.ElectionMessage{
+		Type:     msgType,
+		Term:     e.term,
+		SenderID: config.LocalID,
+		MasterID: e.masterID,
+		TargetID: target,
+	}
+}
+
+// Makes `masterID` the master used by the rest of the elevator
+func (e *election) setMaster(masterID string, term int) {
+	previousID, previousTerm := config.Master()
+	if masterID == previousID && term == previousTerm {
+		return
+	}
+	changed := masterID != previousID
+	config.SetMaster(masterID, term)
+	if !changed {
+		return
+	}
+	if masterID != "" {
+		log.Info("New master elected", "master", masterID, "term", term)
+		metrics.MasterElections.Inc()
+	}
+	journal.Record(journal.Event{Kind: journal.MasterChanged, Peer: masterID, Detail: fmt.Sprintf("term %d", term)})
+	e.masterChan <- masterID
+}
+
+// -----------------------------------------------------------------------------
+// Helpers
+// -----------------------------------------------------------------------------
+// Whether this elevator follows a master it has heard from within MasterTimeout
+func (e *election) masterAlive(now time.Time) bool {
+	return e.role == follower && e.masterID != "" && now.Sub(e.lastHeard) <= config.Cfg.Network.MasterTimeout.Duration
+}
+
+// Whether our master has handed over to `id`, or follows it
+func (e *election) masterMovedTo(id string) bool {
+	if id == e.handoffTo {
+		return true
+	}
+	status, exists := communication.GetElevatorStatuses()[e.masterID]
+	return exists && status.MasterID == id
+}
+
+// Whether the status of `id` shows it as master, and not just a candidate
+func (e *election) ruling(id string) bool {
+	status, exists := communication.GetElevatorStatuses()[id]
+	return exists && status.MasterID == id
+}
+
+// Whether this master gives way to `rival`, ruling for `rivalTerm`. The master
+// followed by more elevators stays, then the one with the newer term, then the
+// lowest ID. Both masters rank from the same statuses, so they agree.
+func (e *election) yieldsTo(rival string, rivalTerm int) bool {
+	statuses := communication.GetElevatorStatuses()
+	peers := alivePeers()
+	ours, theirs := followers(statuses, peers, config.LocalID), followers(statuses, peers, rival)
+	if ours != theirs {
+		return theirs > ours
+	}
+	if rivalTerm != e.term {
+		return rivalTerm > e.term
+	}
+	return rival < config.LocalID
+}
+
+// The number of alive elevators following `masterID`, counting the master itself
+func followers(statuses map[string]communication.ElevatorStatus, peers []string, masterID string) int {
+	count := 1
+	for _, peer := range peers {
+		if status, exists := statuses[peer]; exists && peer != masterID && status.MasterID == masterID {
+			count++
 		}
-	}()
+	}
+	return count
+}
+
+// The elevators seen on the network, always including this one
+func alivePeers() []string {
+	peers := communication.GetPeers()
+	if !contains(peers, config.LocalID) {
+		peers = append(peers, config.LocalID)
+	}
+	return peers
 }
 
-// Assign the lowest ID as master
-func determineMaster(elevatorStates map[string]communication.ElevatorStatus, localID string) string{
-	lowestID := localID
-	for id := range elevatorStates {
-		if id < lowestID {
-			lowestID = id
+func contains(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
 		}
 	}
-	return lowestID
+	return false
 }
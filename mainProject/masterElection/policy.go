@@ -0,0 +1,105 @@
+package masterElection
+
+import (
+	"fmt"
+	"mainProject/communication"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Master selection policy
+// -----------------------------------------------------------------------------
+// The policy picks the elevator that runs first when there is no master, and the
+// successor of a master handing over. It is a chain of rules from the
+// configuration. Each rule compares two candidates by their ElevatorStatus, and
+// the next rule is only asked when a rule cannot tell them apart. The lowest ID
+// always ends the chain, so every elevator with the same statuses picks the same one.
+
+// Negative if `a` should be master rather than `b`, positive for the opposite,
+// and 0 if the rule has no preference
+type rule func(a, b communication.ElevatorStatus) int
+
+var rules = map[string]rule{
+	// Elevators that can serve calls, and whose door is not obstructed
+	"healthy": func(a, b communication.ElevatorStatus) int {
+		return compareBool(healthy(a), healthy(b))
+	},
+	// Higher static priority from the configuration
+	"priority": func(a, b communication.ElevatorStatus) int {
+		return b.Priority - a.Priority
+	},
+	// The elevator that has run the longest. Unknown start times give no preference.
+	"uptime": func(a, b communication.ElevatorStatus) int {
+		if a.StartedAt.IsZero() || b.StartedAt.IsZero() {
+			return 0
+		}
+		return a.StartedAt.Compare(b.StartedAt)
+	},
+	"lowest-id": func(a, b communication.ElevatorStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	},
+}
+
+type policy []rule
+
+func newPolicy(names []string) (policy, error) {
+	p := make(policy, 0, len(names)+1)
+	for _, name := range names {
+		r, exists := rules[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown election rule %q", name)
+		}
+		p = append(p, r)
+	}
+	return append(p, rules["lowest-id"]), nil
+}
+
+// The ID of the candidate preferred by the policy. `candidates` must not be empty.
+func (p policy) best(candidates []communication.ElevatorStatus) string {
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if p.compare(candidate, best) < 0 {
+			best = candidate
+		}
+	}
+	return best.ID
+}
+
+func (p policy) compare(a, b communication.ElevatorStatus) int {
+	for _, r := range p {
+		if result := r(a, b); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func healthy(status communication.ElevatorStatus) bool {
+	return status.State.IsAvailable() && !status.Obstructed
+}
+
+// Prefers true over false
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	}
+	return 1
+}
+
+// The last known statuses of the elevators `ids`. Elevators not heard from yet
+// have only their ID, so the rules fall through to the lowest ID for them.
+func candidates(ids []string) []communication.ElevatorStatus {
+	statuses := communication.GetElevatorStatuses()
+	result := make([]communication.ElevatorStatus, 0, len(ids))
+	for _, id := range ids {
+		status, exists := statuses[id]
+		if !exists {
+			status = communication.ElevatorStatus{ID: id}
+		}
+		result = append(result, status)
+	}
+	return result
+}
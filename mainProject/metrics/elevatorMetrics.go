@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"mainProject/elevio"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Metrics exported by an elevator node
+// -----------------------------------------------------------------------------
+// Hall calls assigned and completed are counted by the master only, so the
+// totals can be summed over all nodes.
+var (
+	HallCallsReceived   = NewCounter("elevator_hall_calls_received_total", "Hall buttons pressed on this elevator.")
+	HallCallsAssigned   = NewCounter("elevator_hall_calls_assigned_total", "Hall calls assigned by this elevator while master.", "elevator")
+	HallCallsReassigned = NewCounter("elevator_hall_calls_reassigned_total", "Hall calls assigned again by this elevator while master, as the elevator first assigned did not take them.", "reason")
+	HallCallsCompleted  = NewCounter("elevator_hall_calls_completed_total", "Hall calls reported finished to this elevator while master.")
+	HallCallService     = NewHistogram("elevator_hall_call_service_seconds", "Time from a hall button press to the door opening at that floor.",
+		[]float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90, 120, 300})
+
+	MessageRetries    = NewCounter("elevator_message_retries_total", "Reliable messages sent again after a missing ack.", "message")
+	MessageFailures   = NewCounter("elevator_message_failures_total", "Reliable messages given up after the last retry.", "message")
+	DuplicatesDropped = NewCounter("elevator_duplicate_messages_dropped_total", "Received messages ignored as duplicates.", "message")
+	MessagesRejected  = NewCounter("elevator_messages_rejected_total", "Received messages dropped as unreadable, of an unknown type, of an incompatible protocol version, too large or incomplete.", "reason")
+	MessagesTooLarge  = NewCounter("elevator_messages_too_large_total", "Messages not sent, as they are longer than Network.MaxMessageSize, or need fragments below protocol version 4.", "message")
+
+	MasterElections    = NewCounter("elevator_master_elections_total", "Times this elevator saw a new master elected.")
+	SplitBrains        = NewCounter("elevator_split_brains_total", "Other masters seen by this elevator while master.")
+	HallCallsRevoked   = NewCounter("elevator_hall_calls_revoked_total", "Hall calls held by more than one elevator and revoked by this elevator while master.")
+	PeersLost          = NewCounter("elevator_peers_lost_total", "Peers reported lost by the peer receiver.")
+	OutOfServiceEvents = NewCounter("elevator_out_of_service_total", "Times this elevator was taken out of service.", "cause")
+)
+
+// Press times of hall calls not yet served. They travel with raw hall calls and
+// assignments, so the service time is measured on the elevator that serves the call.
+var (
+	hallCallPressTimes = make(map[elevio.ButtonEvent]time.Time)
+	pressTimesMutex    sync.Mutex
+)
+
+// Records when a hall call was pressed. The earliest known press is kept, and zero times are ignored.
+func HallCallPressed(call elevio.ButtonEvent, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	pressTimesMutex.Lock()
+	defer pressTimesMutex.Unlock()
+	if existing, exists := hallCallPressTimes[call]; !exists || at.Before(existing) {
+		hallCallPressTimes[call] = at
+	}
+}
+
+// Returns when the hall call was pressed, or the zero time if unknown
+func HallCallPressedAt(call elevio.ButtonEvent) time.Time {
+	pressTimesMutex.Lock()
+	defer pressTimesMutex.Unlock()
+	return hallCallPressTimes[call]
+}
+
+// Observes the service time of a hall call whose door has opened, and forgets its press time
+func HallCallServed(call elevio.ButtonEvent, at time.Time) {
+	pressTimesMutex.Lock()
+	pressedAt, exists := hallCallPressTimes[call]
+	delete(hallCallPressTimes, call)
+	pressTimesMutex.Unlock()
+	if exists {
+		HallCallService.Observe(at.Sub(pressedAt).Seconds())
+	}
+}
+
+// Forgets the press time of a hall call served by another elevator
+func ForgetHallCall(call elevio.ButtonEvent) {
+	pressTimesMutex.Lock()
+	defer pressTimesMutex.Unlock()
+	delete(hallCallPressTimes, call)
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// Counters and histograms exported in the Prometheus text format
+// -----------------------------------------------------------------------------
+// Metrics register themselves when created and are written in that order.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registry      []metric
+	registryMutex sync.Mutex
+)
+
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, m)
+}
+
+// Counter is a monotonically increasing value, optionally split by labels
+type Counter struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mtx    sync.Mutex
+	values map[string]float64 // Keyed by the rendered label set
+}
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{name: name, help: help, labelNames: labelNames, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Increments the counter for the given label values, which must match the label names in number
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	labels := renderLabels(c.labelNames, labelValues)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.values[labels] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labelNames) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name) // Unlabelled counters are always present
+	}
+	labelSets := make([]string, 0, len(c.values))
+	for labels := range c.values {
+		labelSets = append(labelSets, labels)
+	}
+	sort.Strings(labelSets)
+	for _, labels := range labelSets {
+		fmt.Fprintf(w, "%s%s %v\n", c.name, labels, c.values[labels])
+	}
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64 // Upper bounds in increasing order, +Inf is implicit
+
+	mtx    sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, upperBound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%v\"} %d\n", h.name, upperBound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %v\n", h.name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func renderLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Writes every registered metric in the Prometheus text exposition format
+func WriteText(w io.Writer) {
+	registryMutex.Lock()
+	metrics := append([]metric{}, registry...)
+	registryMutex.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w)
+	})
+}
@@ -0,0 +1,176 @@
+package bcast
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"mainProject/config"
+	"math/rand"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Message authentication
+// -----------------------------------------------------------------------------
+// With Network.AuthMode set to sign or require, every packet is wrapped before it
+// is fragmented:
+//
+//	authMagic, sender, time sent, nonce, packet, HMAC-SHA256
+//
+// The HMAC is taken with the shared key over everything before it. A receiver
+// with the key drops packets with a wrong HMAC, packets sent more than
+// Network.ReplayWindow ago or ahead, and packets whose sender and nonce it has
+// already seen within the window. So a host without the key can neither forge a
+// message nor replay a recorded one later. In require mode unsigned packets are
+// dropped as well, while sign mode still reads them, and with auth off signed
+// packets are read without checking them. So a fleet on version 5 can start
+// signing one elevator at a time, and then require it one elevator at a time.
+// Signed packets can only be read from protocol version 5.
+
+const authMagic = 0xA7
+
+const macSize = sha256.Size
+
+// Auth modes besides off
+const (
+	authSign    = "sign"
+	authRequire = "require"
+)
+
+func signing() bool {
+	return config.Cfg.Network.AuthMode == authSign || config.Cfg.Network.AuthMode == authRequire
+}
+
+func sign(packet []byte) []byte {
+	signed := []byte{authMagic}
+	signed = appendString(signed, config.LocalID)
+	signed = binary.AppendVarint(signed, time.Now().UnixNano())
+	signed = binary.AppendUvarint(signed, rand.Uint64())
+	signed = append(signed, packet...)
+	mac := hmac.New(sha256.New, config.Cfg.Network.AuthKey)
+	mac.Write(signed)
+	return mac.Sum(signed)
+}
+
+// Nonces seen from each sender within the replay window. Not safe for concurrent
+// use, as every receiver has its own.
+type replayGuard struct {
+	seen      map[string]map[uint64]time.Time
+	lastSweep time.Time
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{seen: make(map[string]map[uint64]time.Time)}
+}
+
+// Checks a packet received on a port and returns the packet inside it, or a
+// *rejection. Unsigned packets are passed on as they are, unless they are
+// required to be signed.
+func (g *replayGuard) verify(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != authMagic {
+		if config.Cfg.Network.AuthMode == authRequire {
+			return nil, &rejection{"unauthenticated", "unsigned message"}
+		}
+		return data, nil
+	}
+	if len(data) < 1+macSize {
+		return nil, &rejection{"unreadable", "truncated signed message"}
+	}
+	signed, sum := data[:len(data)-macSize], data[len(data)-macSize:]
+	d := decoder{data: signed[1:]}
+	sender := string(d.bytes(d.length()))
+	sent := time.Unix(0, d.varint())
+	nonce := d.uvarint()
+	if d.err != nil {
+		return nil, &rejection{"unreadable", "truncated signed message"}
+	}
+	if !signing() {
+		return d.data, nil // Not checked without a key
+	}
+
+	mac := hmac.New(sha256.New, config.Cfg.Network.AuthKey)
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return nil, &rejection{"bad_mac", "HMAC does not match, wrong key or forged message"}
+	}
+	window := config.Cfg.Network.ReplayWindow.Duration
+	if age := time.Since(sent); age > window || age < -window {
+		return nil, &rejection{"stale", fmt.Sprintf("message from %q sent outside the replay window, are the clocks in sync?", sender)}
+	}
+
+	g.sweep(window)
+	nonces, exists := g.seen[sender]
+	if !exists {
+		nonces = make(map[uint64]time.Time)
+		g.seen[sender] = nonces
+	}
+	if _, replayed := nonces[nonce]; replayed {
+		return nil, &rejection{"replayed", fmt.Sprintf("nonce seen before from %q", sender)}
+	}
+	nonces[nonce] = sent
+	return d.data, nil
+}
+
+// Forgets the nonces of packets that are outside the window anyway, at most once per window
+func (g *replayGuard) sweep(window time.Duration) {
+	if time.Since(g.lastSweep) < window {
+		return
+	}
+	g.lastSweep = time.Now()
+	for sender, nonces := range g.seen {
+		for nonce, sent := range nonces {
+			if time.Since(sent) > window {
+				delete(nonces, nonce)
+			}
+		}
+		if len(nonces) == 0 {
+			delete(g.seen, sender)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Peer heartbeats
+// -----------------------------------------------------------------------------
+// Heartbeats are raw packets holding the elevator ID, sent by network/peers
+// without an envelope. From protocol version 8 they are signed like messages, so
+// a host without the key cannot make an elevator appear or keep a lost one alive.
+// While this elevator sends an older version, unsigned heartbeats are read even
+// in require mode, as the elevators not yet upgraded send nothing else.
+
+const minSignedHeartbeatVersion = 8
+
+// The heartbeat packet of elevator `id`
+func SignHeartbeat(id string) []byte {
+	if signing() && config.Cfg.Network.ProtocolVersion >= minSignedHeartbeatVersion {
+		return sign([]byte(id))
+	}
+	return []byte(id)
+}
+
+// Checks the heartbeats received on one port. Not safe for concurrent use.
+type HeartbeatVerifier struct {
+	port    int
+	replays *replayGuard
+}
+
+func NewHeartbeatVerifier(port int) *HeartbeatVerifier {
+	return &HeartbeatVerifier{port: port, replays: newReplayGuard()}
+}
+
+// Returns the ID in a received heartbeat, or "" if it is dropped
+func (v *HeartbeatVerifier) Verify(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if data[0] != authMagic && config.Cfg.Network.ProtocolVersion < minSignedHeartbeatVersion {
+		return string(data)
+	}
+	packet, err := v.replays.verify(data)
+	if err != nil {
+		reject(v.port, err)
+		return ""
+	}
+	return string(packet)
+}
@@ -0,0 +1,186 @@
+package bcast
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"mainProject/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Loads a configuration with `mode` and `version` through the flags, so a
+// version or mode the configuration cannot reach fails the test
+func withAuth(t *testing.T, mode string, version int) {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ELEVATOR_CONFIG", "")
+	t.Setenv("ELEVATOR_AUTH_KEY_FILE", "")
+	cfg, err := config.Load([]string{"-auth-mode", mode, "-auth-key-file", keyFile, "-protocol-version", strconv.Itoa(version)})
+	if err != nil {
+		t.Fatalf("configuration with auth mode %s at version %d: %v", mode, version, err)
+	}
+	saved := config.Cfg
+	t.Cleanup(func() { config.Cfg = saved })
+	config.Cfg = cfg
+}
+
+// A packet signed by `sender` as if sent at `sent`, with `nonce`
+func signedAt(sender string, sent time.Time, nonce uint64, packet []byte) []byte {
+	signed := []byte{authMagic}
+	signed = appendString(signed, sender)
+	signed = binary.AppendVarint(signed, sent.UnixNano())
+	signed = binary.AppendUvarint(signed, nonce)
+	signed = append(signed, packet...)
+	mac := hmac.New(sha256.New, config.Cfg.Network.AuthKey)
+	mac.Write(signed)
+	return mac.Sum(signed)
+}
+
+func rejectionReason(err error) string {
+	var r *rejection
+	if errors.As(err, &r) {
+		return r.reason
+	}
+	return ""
+}
+
+func TestVerifySignedMessages(t *testing.T) {
+	withAuth(t, authRequire, config.CurrentProtocol)
+	window := config.Cfg.Network.ReplayWindow.Duration
+	packet := []byte("assignment")
+
+	badMAC := sign(packet)
+	badMAC[len(badMAC)-1] ^= 1
+	altered := sign(packet)
+	altered[len(altered)-macSize-1] ^= 1
+	tests := []struct {
+		name       string
+		data       []byte
+		wantReason string // "" if the packet is read
+	}{
+		{"signed", sign(packet), ""},
+		{"bad MAC", badMAC, "bad_mac"},
+		{"altered packet", altered, "bad_mac"},
+		{"sent before the window", signedAt("elevator_2", time.Now().Add(-2*window), 1, packet), "stale"},
+		{"sent after the window", signedAt("elevator_2", time.Now().Add(2*window), 2, packet), "stale"},
+		{"within the window", signedAt("elevator_2", time.Now().Add(-window/2), 3, packet), ""},
+		{"truncated", sign(packet)[:macSize-1], "unreadable"},
+		{"unsigned", packet, "unauthenticated"},
+		{"empty", nil, "unauthenticated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, err := newReplayGuard().verify(tt.data)
+			if reason := rejectionReason(err); reason != tt.wantReason || (err != nil && reason == "") {
+				t.Fatalf("verify = %v, want rejection %q", err, tt.wantReason)
+			}
+			if tt.wantReason == "" && string(read) != string(packet) {
+				t.Errorf("read %q, want %q", read, packet)
+			}
+		})
+	}
+}
+
+func TestVerifyReplayedNonces(t *testing.T) {
+	withAuth(t, authSign, config.CurrentProtocol)
+	g := newReplayGuard()
+	sent := time.Now()
+
+	first := signedAt("elevator_2", sent, 7, []byte("status"))
+	if _, err := g.verify(first); err != nil {
+		t.Fatalf("first copy rejected: %v", err)
+	}
+	if _, err := g.verify(first); rejectionReason(err) != "replayed" {
+		t.Errorf("replayed copy: %v, want rejection replayed", err)
+	}
+	if _, err := g.verify(signedAt("elevator_2", sent, 8, []byte("status"))); err != nil {
+		t.Errorf("new nonce rejected: %v", err)
+	}
+	if _, err := g.verify(signedAt("elevator_3", sent, 7, []byte("status"))); err != nil {
+		t.Errorf("same nonce from another sender rejected: %v", err)
+	}
+}
+
+func TestVerifyUnsignedByMode(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantUnsigned bool // Whether unsigned packets are read
+	}{
+		{"off", true},
+		{authSign, true},
+		{authRequire, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			withAuth(t, tt.mode, config.CurrentProtocol)
+			read, err := newReplayGuard().verify([]byte("status"))
+			if tt.wantUnsigned && (err != nil || string(read) != "status") {
+				t.Errorf("unsigned packet read as %q, %v, want it passed on", read, err)
+			}
+			if !tt.wantUnsigned && rejectionReason(err) != "unauthenticated" {
+				t.Errorf("unsigned packet: %v, want rejection unauthenticated", err)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutKeyReadsSignedUnchecked(t *testing.T) {
+	withAuth(t, authSign, config.CurrentProtocol)
+	forged := sign([]byte("status"))
+	forged[len(forged)-1] ^= 1
+	config.Cfg.Network.AuthMode = "off"
+	if read, err := newReplayGuard().verify(forged); err != nil || string(read) != "status" {
+		t.Errorf("signed packet read as %q, %v with auth off, want it read unchecked", read, err)
+	}
+}
+
+func TestSignedHeartbeats(t *testing.T) {
+	withAuth(t, authRequire, minSignedHeartbeatVersion)
+	v := NewHeartbeatVerifier(0)
+
+	heartbeat := SignHeartbeat("elevator_2")
+	if string(heartbeat) == "elevator_2" {
+		t.Fatalf("heartbeat sent unsigned")
+	}
+	if id := v.Verify(heartbeat); id != "elevator_2" {
+		t.Fatalf("signed heartbeat read as %q, want elevator_2", id)
+	}
+	if id := v.Verify(heartbeat); id != "" {
+		t.Errorf("replayed heartbeat read as %q", id)
+	}
+	if id := v.Verify([]byte("elevator_3")); id != "" {
+		t.Errorf("unsigned heartbeat read as %q while signing is required", id)
+	}
+	forged := SignHeartbeat("elevator_2")
+	forged[len(forged)-1] ^= 1
+	if id := v.Verify(forged); id != "" {
+		t.Errorf("heartbeat with a wrong HMAC read as %q", id)
+	}
+}
+
+func TestHeartbeatsBeforeVersion8(t *testing.T) {
+	withAuth(t, authRequire, minSignedHeartbeatVersion-1)
+	v := NewHeartbeatVerifier(0)
+
+	if heartbeat := SignHeartbeat("elevator_2"); string(heartbeat) != "elevator_2" {
+		t.Errorf("heartbeat signed below version %d", minSignedHeartbeatVersion)
+	}
+	if id := v.Verify([]byte("elevator_3")); id != "elevator_3" {
+		t.Errorf("unsigned heartbeat read as %q, want elevator_3 until the fleet sends version %d", id, minSignedHeartbeatVersion)
+	}
+
+	config.Cfg.Network.ProtocolVersion = minSignedHeartbeatVersion
+	signed := SignHeartbeat("elevator_4") // From an elevator already upgraded
+	config.Cfg.Network.ProtocolVersion = minSignedHeartbeatVersion - 1
+	if id := v.Verify(signed); id != "elevator_4" {
+		t.Errorf("signed heartbeat read as %q, want elevator_4", id)
+	}
+}
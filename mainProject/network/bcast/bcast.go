@@ -1,74 +1,105 @@
 package bcast
 
 import (
+	"mainProject/config"
+	"mainProject/logging"
+	"mainProject/metrics"
 	"mainProject/network/conn"
-	"encoding/json"
 	"fmt"
 	"net"
 	"reflect"
 )
 
-const bufSize = 1024
+const bufSize = 1024 // Longest datagram. Longer messages are fragmented
 
-// Encodes received values from `chans` into type-tagged JSON, then broadcasts
-// it on `port`
+var log = logging.For("network")
+
+// Encodes received values from `chans` with the configured codec in a versioned
+// envelope (see protocol.go), then broadcasts it on `port`
 func Transmitter(port int, chans ...interface{}) {
 	checkArgs(chans...)
-	typeNames := make([]string, len(chans))
-	selectCases := make([]reflect.SelectCase, len(typeNames))
+	codec, err := CodecByName(config.Cfg.Network.Codec)
+	if err != nil {
+		panic(err)
+	}
+	selectCases := make([]reflect.SelectCase, len(chans))
 	for i, ch := range chans {
 		selectCases[i] = reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
 			Chan: reflect.ValueOf(ch),
 		}
-		typeNames[i] = reflect.TypeOf(ch).Elem().String()
 	}
 
 	conn := conn.DialBroadcastUDP(port)
 	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
 	for {
-		chosen, value, _ := reflect.Select(selectCases)
-		jsonstr, _ := json.Marshal(value.Interface())
-		ttj, _ := json.Marshal(typeTaggedJSON{
-			TypeId: typeNames[chosen],
-			JSON:   jsonstr,
-		})
-		if len(ttj) > bufSize {
-		    panic(fmt.Sprintf(
-		        "Tried to send a message longer than the buffer size (length: %d, buffer size: %d)\n\t'%s'\n"+
-		        "Either send smaller packets, or go to network/bcast/bcast.go and increase the buffer size",
-		        len(ttj), bufSize, string(ttj)))
-		}
-		conn.WriteTo(ttj, addr)
-    		
+		_, value, _ := reflect.Select(selectCases)
+		ttj, err := Pack(codec, value.Interface())
+		if err != nil {
+			log.Error("Could not encode message", "port", port, "type", value.Type().String(), "err", err)
+			continue
+		}
+		if signing() {
+			ttj = sign(ttj)
+		}
+		datagrams, err := fragment(ttj)
+		if err != nil {
+			metrics.MessagesTooLarge.Inc(registeredName(value.Type()))
+			log.Error("Dropped message", "port", port, "type", value.Type().String(), "err", err)
+			continue
+		}
+		for _, datagram := range datagrams {
+			conn.WriteTo(datagram, addr)
+		}
 	}
 }
 
-// Matches type-tagged JSON received on `port` to element types of `chans`, then
-// sends the decoded value on the corresponding channel
+// Matches messages received on `port` to element types of `chans` by their
+// registered names, then sends the decoded value on the corresponding channel.
+// Fragmented messages are reassembled first (see fragment.go), and messages that
+// fail authentication (see auth.go) or are of incompatible protocol versions are dropped.
 func Receiver(port int, chans ...interface{}) {
 	checkArgs(chans...)
 	chansMap := make(map[string]interface{})
 	for _, ch := range chans {
-		chansMap[reflect.TypeOf(ch).Elem().String()] = ch
+		chansMap[registeredName(reflect.TypeOf(ch).Elem())] = ch
 	}
 
 	var buf [bufSize]byte
+	fragments := newReassembler(port)
+	replays := newReplayGuard()
 	conn := conn.DialBroadcastUDP(port)
 	for {
 		n, _, e := conn.ReadFrom(buf[0:])
 		if e != nil {
-			fmt.Printf("bcast.Receiver(%d, ...):ReadFrom() failed: \"%+v\"\n", port, e)
+			log.Error("ReadFrom failed", "port", port, "err", e)
 		}
 
-		var ttj typeTaggedJSON
-		json.Unmarshal(buf[0:n], &ttj)
-		ch, ok := chansMap[ttj.TypeId]
-		if !ok {
+		packet := buf[0:n]
+		if n > 0 && buf[0] == fragmentMagic {
+			if packet = fragments.add(buf[1:n]); packet == nil {
+				continue // Waiting for the other fragments
+			}
+		}
+		packet, err := replays.verify(packet)
+		if err != nil {
+			reject(port, err)
 			continue
 		}
+		msg, err := decodeEnvelope(packet)
+		if err != nil {
+			reject(port, err)
+			continue
+		}
+		ch, ok := chansMap[msg.Type]
+		if !ok {
+			continue // Another type sharing the port
+		}
 		v := reflect.New(reflect.TypeOf(ch).Elem())
-		json.Unmarshal(ttj.JSON, v.Interface())
+		if err := msg.codec.Unmarshal(msg.JSON, v.Interface()); err != nil {
+			reject(port, err)
+			continue
+		}
 		reflect.Select([]reflect.SelectCase{{
 			Dir:  reflect.SelectSend,
 			Chan: reflect.ValueOf(ch),
@@ -77,14 +108,10 @@ func Receiver(port int, chans ...interface{}) {
 	}
 }
 
-type typeTaggedJSON struct {
-	TypeId string
-	JSON   []byte
-}
-
 // Checks that args to Tx'er/Rx'er are valid:
 //  All args must be channels
 //  Element types of channels must be encodable with JSON
+//  Element types of channels must be registered
 //  No element types are repeated
 // Implementation note:
 //  - Why there is no `isMarshalable()` function in encoding/json is a mystery,
@@ -116,6 +143,12 @@ func checkArgs(chans ...interface{}) {
 		}
 		elemTypes[i] = elemType
 
+		if registeredName(elemType) == "" {
+			panic(fmt.Sprintf(
+				"Channel element type '%s' is not registered, call bcast.Register for it (arg# %d)",
+				elemType.String(), i+1))
+		}
+
 		// Element type must be encodable with JSON
 		checkTypeRecursive(elemType, []int{i+1})
 
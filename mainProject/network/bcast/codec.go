@@ -0,0 +1,314 @@
+package bcast
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Codecs
+// -----------------------------------------------------------------------------
+// A codec encodes the messages sent with bcast. The codec of the sender is
+// chosen per deployment (Network.Codec), and receivers read every codec, as the
+// first byte of a packet tells them apart. So a fleet can switch codec one
+// elevator at a time, once every elevator runs a version that reads the new one.
+
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON   Codec = jsonCodec{}
+	Binary Codec = binaryCodec{}
+)
+
+// Every codec, each known by its name in the configuration
+var Codecs = []Codec{JSON, Binary}
+
+func CodecByName(name string) (Codec, error) {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// -----------------------------------------------------------------------------
+// Binary codec
+// -----------------------------------------------------------------------------
+// A compact encoding driven by the Go types, so both sides must have the same
+// fields in the same order:
+//
+//	int kinds          zig-zag varint
+//	uint kinds         varint
+//	bool               one byte
+//	float              8 bytes, little endian
+//	string, []byte     varint length, then the bytes
+//	slice              varint length, then the elements
+//	array              the elements
+//	map                varint length, then key and value pairs sorted by key
+//	pointer            one byte, 1 if set, then the value
+//	time.Time          zig-zag varint of Unix nanoseconds, 0 for the zero time
+//	struct             varint length in bytes, then the exported fields in order
+//
+// Structs carry their length, so a field appended to a message is compatible:
+// older readers skip it, and newer readers leave it zero when it is missing.
+// Fields must never be reordered or removed.
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	return appendValue(nil, reflect.ValueOf(v))
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("binary codec needs a non-nil pointer to decode into")
+	}
+	d := decoder{data: data}
+	d.value(target.Elem())
+	return d.err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return binary.AppendVarint(buf, 0), nil
+		}
+		return binary.AppendVarint(buf, t.UnixNano()), nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Float32, reflect.Float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = binary.AppendUvarint(buf, uint64(v.Len()))
+			return append(buf, v.Bytes()...), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return appendElements(buf, v)
+	case reflect.Array:
+		return appendElements(buf, v)
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		buf = binary.AppendUvarint(buf, uint64(len(keys)))
+		var err error
+		for _, key := range keys {
+			if buf, err = appendValue(buf, key); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, v.MapIndex(key)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, errors.New("binary codec cannot encode a nil interface")
+		}
+		return appendValue(buf, v.Elem())
+	case reflect.Struct:
+		var fields []byte
+		var err error
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if fields, err = appendValue(fields, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(fields)))
+		return append(buf, fields...), nil
+	}
+	return nil, fmt.Errorf("binary codec cannot encode '%s'", v.Type())
+}
+
+func appendElements(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if buf, err = appendValue(buf, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Reads values from `data`. The first error stops decoding, and is kept in `err`.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("binary codec: "+format, args...)
+	}
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("truncated varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("truncated varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+// A length that cannot be longer than the data left, as every element takes at least one byte
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("length %d longer than the %d bytes left", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if n > len(d.data) {
+		d.fail("truncated data")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) value(v reflect.Value) {
+	if d.err != nil {
+		return
+	}
+	if v.Type() == timeType {
+		if nanos := d.varint(); nanos != 0 {
+			v.Set(reflect.ValueOf(time.Unix(0, nanos)))
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := d.varint()
+		if v.OverflowInt(x) {
+			d.fail("%d overflows '%s'", x, v.Type())
+			return
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x := d.uvarint()
+		if v.OverflowUint(x) {
+			d.fail("%d overflows '%s'", x, v.Type())
+			return
+		}
+		v.SetUint(x)
+	case reflect.Bool:
+		b := d.bytes(1)
+		if b != nil {
+			v.SetBool(b[0] != 0)
+		}
+	case reflect.Float32, reflect.Float64:
+		b := d.bytes(8)
+		if b != nil {
+			v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+	case reflect.String:
+		v.SetString(string(d.bytes(d.length())))
+	case reflect.Slice:
+		n := d.length()
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), d.bytes(n)...))
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			d.value(s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			d.value(v.Index(i))
+		}
+	case reflect.Map:
+		n := d.length()
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n && d.err == nil; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.value(key)
+			d.value(elem)
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		b := d.bytes(1)
+		if b == nil || b[0] == 0 {
+			return
+		}
+		elem := reflect.New(v.Type().Elem())
+		d.value(elem.Elem())
+		v.Set(elem)
+	case reflect.Struct:
+		fields := decoder{data: d.bytes(d.length())}
+		for i := 0; i < v.NumField() && len(fields.data) > 0; i++ {
+			if v.Type().Field(i).IsExported() {
+				fields.value(v.Field(i))
+			}
+		}
+		if fields.err != nil {
+			d.err, d.data = fields.err, nil
+		}
+		// Fields appended by a newer version are left in fields.data and skipped
+	default:
+		d.fail("cannot decode '%s'", v.Type())
+	}
+}
@@ -0,0 +1,382 @@
+package bcast
+
+import (
+	"errors"
+	"mainProject/config"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Messages shaped like those of the communication package, which imports this
+// one and so cannot be used here
+type testQueue [][3]bool
+
+type testStatus struct {
+	ID         string
+	Floor      int
+	State      int
+	Direction  int
+	Queue      testQueue
+	Available  bool
+	Timestamp  time.Time
+	MasterID   string
+	MasterTerm int
+	Priority   int
+	HallOrders [][2]int
+}
+
+type testDelivery struct {
+	Sender      string
+	Incarnation int64
+	Stream      string
+	Seq         int
+}
+
+type testAssignment struct {
+	TargetID  string
+	Floor     int
+	Button    int
+	SeqNum    int
+	PressedAt time.Time
+	OrderID   string
+	Term      int
+	Delivery  testDelivery
+}
+
+type testElection struct {
+	Type     int
+	Term     int
+	SenderID string
+	MasterID string
+	TargetID string
+}
+
+// Every kind the binary codec encodes
+type testKinds struct {
+	Int      int
+	Negative int64
+	Small    int8
+	Uint     uint32
+	Flag     bool
+	Float    float64
+	Text     string
+	Raw      []byte
+	Items    []testDelivery
+	Fixed    [2]int
+	Lookup   map[string]int
+	Set      *testDelivery
+	Unset    *testDelivery
+	At       time.Time
+	Never    time.Time
+	Nested   testElection
+	internal int
+}
+
+// A testElection as an older version sent it, before TargetID was appended
+type testElectionV1 struct {
+	Type     int
+	Term     int
+	SenderID string
+	MasterID string
+}
+
+func TestMain(m *testing.M) {
+	config.Cfg = config.Default()
+	config.LocalID = "elevator_1"
+	Register("test_status", testStatus{})
+	Register("test_assignment", testAssignment{})
+	Register("test_election", testElection{})
+	Register("test_kinds", testKinds{})
+	os.Exit(m.Run())
+}
+
+var sampleTime = time.Unix(0, time.Date(2025, 3, 14, 9, 26, 53, 589793238, time.UTC).UnixNano())
+
+func sampleStatus(floors int) testStatus {
+	queue := make(testQueue, floors)
+	queue[1][2] = true
+	queue[floors-1][1] = true
+	hallOrders := make([][2]int, floors)
+	hallOrders[floors-1][1] = 2
+	hallOrders[0][0] = 1
+	return testStatus{
+		ID:         "elevator_2",
+		Floor:      1,
+		State:      2,
+		Direction:  1,
+		Queue:      queue,
+		Available:  true,
+		Timestamp:  sampleTime,
+		MasterID:   "elevator_1",
+		MasterTerm: 3,
+		Priority:   1,
+		HallOrders: hallOrders,
+	}
+}
+
+func sampleAssignment() testAssignment {
+	return testAssignment{
+		TargetID:  "elevator_2",
+		Floor:     3,
+		Button:    1,
+		SeqNum:    17,
+		PressedAt: sampleTime,
+		OrderID:   "elevator_3-m8a1b2c3-12",
+		Term:      3,
+		Delivery:  testDelivery{Sender: "elevator_1", Incarnation: sampleTime.UnixNano(), Stream: "assignment", Seq: 17},
+	}
+}
+
+func sampleElection() testElection {
+	return testElection{Type: 1, Term: 3, SenderID: "elevator_2", MasterID: "elevator_1", TargetID: "elevator_1"}
+}
+
+func sampleKinds() testKinds {
+	return testKinds{
+		Int:      42,
+		Negative: -1 << 40,
+		Small:    -128,
+		Uint:     1<<32 - 1,
+		Flag:     true,
+		Float:    -2.5,
+		Text:     "heisann",
+		Raw:      []byte{0, 1, 0xB1, 0xFF},
+		Items:    []testDelivery{{Sender: "a", Seq: 1}, {Sender: "b", Incarnation: -7}},
+		Fixed:    [2]int{-1, 1},
+		Lookup:   map[string]int{"b": 2, "a": 1, "c": -3},
+		Set:      &testDelivery{Stream: "ack"},
+		At:       sampleTime,
+		Nested:   sampleElection(),
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Binary codec
+// -----------------------------------------------------------------------------
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"every kind", sampleKinds()},
+		{"status", sampleStatus(4)},
+		{"status of many floors", sampleStatus(40)},
+		{"assignment", sampleAssignment()},
+		{"election", sampleElection()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Binary.Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			decoded := reflect.New(reflect.TypeOf(tt.value))
+			if err := Binary.Unmarshal(data, decoded.Interface()); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if got := decoded.Elem().Interface(); !reflect.DeepEqual(got, tt.value) {
+				t.Errorf("decoded %+v, want %+v", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestBinaryMapsEncodeInKeyOrder(t *testing.T) {
+	a, _ := Binary.Marshal(map[string]int{"a": 1, "b": 2, "c": 3})
+	for i := 0; i < 10; i++ {
+		b, _ := Binary.Marshal(map[string]int{"c": 3, "b": 2, "a": 1})
+		if string(a) != string(b) {
+			t.Fatalf("equal maps encoded as %x and %x", a, b)
+		}
+	}
+}
+
+func TestBinaryAppendedFields(t *testing.T) {
+	newer := sampleElection()
+	data, err := Binary.Marshal(newer)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var older testElectionV1
+	if err := Binary.Unmarshal(data, &older); err != nil {
+		t.Fatalf("an older reader could not skip the appended field: %v", err)
+	}
+	if want := (testElectionV1{newer.Type, newer.Term, newer.SenderID, newer.MasterID}); older != want {
+		t.Errorf("older reader decoded %+v, want %+v", older, want)
+	}
+
+	data, err = Binary.Marshal(older)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var fromOlder testElection
+	if err := Binary.Unmarshal(data, &fromOlder); err != nil {
+		t.Fatalf("a newer reader could not read an older message: %v", err)
+	}
+	if fromOlder.TargetID != "" || fromOlder.MasterID != newer.MasterID {
+		t.Errorf("newer reader decoded %+v, want the appended field left empty", fromOlder)
+	}
+}
+
+func TestBinaryTruncated(t *testing.T) {
+	for _, value := range []interface{}{sampleKinds(), sampleStatus(4), sampleAssignment(), sampleElection()} {
+		data, err := Binary.Marshal(value)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		for n := 0; n < len(data); n++ {
+			decoded := reflect.New(reflect.TypeOf(value))
+			if err := Binary.Unmarshal(data[:n], decoded.Interface()); err == nil {
+				t.Errorf("%T cut to %d of %d bytes decoded without an error", value, n, len(data))
+			}
+		}
+	}
+}
+
+func TestBinaryRejectsOverflowAndLongLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		target interface{}
+	}{
+		{"int8 overflow", []byte{0x80, 0x04}, new(int8)}, // Zig-zag 256
+		{"length past the end", []byte{0x7F, 'a'}, new(string)},
+		{"slice longer than the data", []byte{0x05, 0x00}, new([]int)},
+		{"unterminated varint", []byte{0xFF, 0xFF}, new(int)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Binary.Unmarshal(tt.data, tt.target); err == nil {
+				t.Errorf("decoded %x without an error", tt.data)
+			}
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Envelopes
+// -----------------------------------------------------------------------------
+func TestPackUnpackRoundTrip(t *testing.T) {
+	for _, codec := range Codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			packet, err := Pack(codec, sampleAssignment())
+			if err != nil {
+				t.Fatalf("Pack: %v", err)
+			}
+			var decoded testAssignment
+			if err := Unpack(packet, &decoded); err != nil {
+				t.Fatalf("Unpack: %v", err)
+			}
+			if !decoded.PressedAt.Equal(sampleTime) {
+				t.Errorf("PressedAt = %v, want %v", decoded.PressedAt, sampleTime)
+			}
+			decoded.PressedAt = sampleTime
+			if decoded != sampleAssignment() {
+				t.Errorf("decoded %+v, want %+v", decoded, sampleAssignment())
+			}
+
+			var wrongType testElection
+			if err := Unpack(packet, &wrongType); err == nil {
+				t.Errorf("unpacked an assignment into an election message")
+			}
+		})
+	}
+}
+
+func TestDecodeBinaryEnvelope(t *testing.T) {
+	packet, err := Pack(Binary, sampleElection())
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	if packet[0] != binaryMagic {
+		t.Fatalf("binary packet starts with %#x, want %#x", packet[0], binaryMagic)
+	}
+	e, err := decodeBinaryEnvelope(packet[1:])
+	if err != nil {
+		t.Fatalf("decodeBinaryEnvelope: %v", err)
+	}
+	if e.Version != config.CurrentProtocol || e.MinVersion != minBinaryVersion || e.Type != "test_election" || e.Sender != config.LocalID || e.codec != Binary {
+		t.Errorf("envelope = %+v, want version %d, min version %d, type test_election from %s", e, config.CurrentProtocol, minBinaryVersion, config.LocalID)
+	}
+	payload, _ := Binary.Marshal(sampleElection())
+	if string(e.JSON) != string(payload) {
+		t.Errorf("payload = %x, want %x", e.JSON, payload)
+	}
+
+	// Every cut inside the header is rejected as unreadable, and never panics
+	header := packet[1 : len(packet)-len(payload)]
+	for n := 0; n < len(header); n++ {
+		_, err := decodeBinaryEnvelope(header[:n])
+		var r *rejection
+		if !errors.As(err, &r) || r.reason != "unreadable" {
+			t.Errorf("header cut to %d of %d bytes: err = %v, want an unreadable rejection", n, len(header), err)
+		}
+	}
+	// A cut inside the payload is left to the codec
+	cut := packet[:len(packet)-1]
+	var decoded testElection
+	if err := Unpack(cut, &decoded); err == nil {
+		t.Errorf("unpacked a packet missing its last byte")
+	}
+}
+
+func TestDecodeBinaryEnvelopeChecksVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     []byte
+		wantReason string
+	}{
+		{"no version", []byte{0x00, 0x03, 0x00, 0x00}, "unreadable"},
+		{"needs a newer reader", []byte{0x7F, 0x7F, 0x00, 0x00}, "version_too_new"},
+		{"unregistered type", append([]byte{0x07, 0x03, 0x04}, "nope\x00"...), "unknown_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeBinaryEnvelope(tt.header)
+			var r *rejection
+			if !errors.As(err, &r) || r.reason != tt.wantReason {
+				t.Errorf("err = %v, want a %s rejection", err, tt.wantReason)
+			}
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Benchmarks
+// -----------------------------------------------------------------------------
+// Whole packets with the envelope, the way bcast sends them. Compare the codecs with
+//
+//	go test ./network/bcast -run '^$' -bench Pack -benchmem
+
+func benchmarkPack(b *testing.B, value interface{}) {
+	for _, codec := range Codecs {
+		packet, err := Pack(codec, value)
+		if err != nil {
+			b.Fatalf("Pack with %s: %v", codec.Name(), err)
+		}
+		b.Run(codec.Name()+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(packet)), "bytes/packet")
+			for i := 0; i < b.N; i++ {
+				Pack(codec, value)
+			}
+		})
+		b.Run(codec.Name()+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(packet)), "bytes/packet")
+			t := reflect.TypeOf(value)
+			for i := 0; i < b.N; i++ {
+				if err := Unpack(packet, reflect.New(t).Interface()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPackStatus(b *testing.B)           { benchmarkPack(b, sampleStatus(4)) }
+func BenchmarkPackStatusManyFloors(b *testing.B) { benchmarkPack(b, sampleStatus(12)) }
+func BenchmarkPackAssignment(b *testing.B)       { benchmarkPack(b, sampleAssignment()) }
+func BenchmarkPackElection(b *testing.B)         { benchmarkPack(b, sampleElection()) }
@@ -0,0 +1,178 @@
+package bcast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"mainProject/config"
+	"mainProject/metrics"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Fragmentation
+// -----------------------------------------------------------------------------
+// A packet longer than bufSize is split into fragments that each fit in one
+// datagram:
+//
+//	fragmentMagic, sender, message ID, index, count, part of the packet
+//
+// with the sender as a length-prefixed string and the numbers as varints. The
+// receiver collects the fragments of each (sender, message ID) and handles the
+// packet once all have arrived. Messages still incomplete after
+// Network.FragmentTimeout are dropped, and so are messages longer than
+// Network.MaxMessageSize, on both sides. Fragments can only be read from
+// protocol version 4, so while an older version is sent, messages that do not
+// fit in one datagram are not sent at all.
+
+const fragmentMagic = 0xF7
+
+const minFragmentVersion = 4
+
+// Messages whose fragments are collected at once. Older ones are dropped first.
+const maxPendingMessages = 64
+
+// Shared by every transmitter of the process, as several may send on one port.
+// Starts at random so a restarted elevator does not reuse IDs still being collected.
+var nextMessageID = rand.Uint64()
+
+// Splits `packet` into datagrams of at most bufSize bytes. A packet that fits is
+// returned as it is.
+func fragment(packet []byte) ([][]byte, error) {
+	if len(packet) > config.Cfg.Network.MaxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is longer than Network.MaxMessageSize %d", len(packet), config.Cfg.Network.MaxMessageSize)
+	}
+	if len(packet) <= bufSize {
+		return [][]byte{packet}, nil
+	}
+	if version := config.Cfg.Network.ProtocolVersion; version < minFragmentVersion {
+		return nil, fmt.Errorf("message of %d bytes needs fragments, which protocol version %d cannot read", len(packet), version)
+	}
+	id := atomic.AddUint64(&nextMessageID, 1)
+	header := func(index, count int) []byte {
+		h := []byte{fragmentMagic}
+		h = appendString(h, config.LocalID)
+		h = binary.AppendUvarint(h, id)
+		h = binary.AppendUvarint(h, uint64(index))
+		return binary.AppendUvarint(h, uint64(count))
+	}
+	// The longest header is the one of the last fragment, so every part gets that much room
+	partSize := bufSize - len(header(len(packet), len(packet)))
+	if partSize <= 0 {
+		return nil, fmt.Errorf("elevator ID is too long to fragment messages")
+	}
+	count := (len(packet) + partSize - 1) / partSize
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*partSize, len(packet))
+		fragments = append(fragments, append(header(i, count), packet[i*partSize:end]...))
+	}
+	return fragments, nil
+}
+
+type messageKey struct {
+	sender string
+	id     uint64
+}
+
+type partialMessage struct {
+	parts    [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// Collects the fragments received on one port. Not safe for concurrent use, as
+// every receiver has its own.
+type reassembler struct {
+	port    int
+	pending map[messageKey]*partialMessage
+}
+
+func newReassembler(port int) *reassembler {
+	return &reassembler{port: port, pending: make(map[messageKey]*partialMessage)}
+}
+
+// Adds a fragment, without its magic byte. Returns the whole packet once its last
+// fragment has arrived, and nil before that.
+func (r *reassembler) add(data []byte) []byte {
+	r.expire()
+
+	d := decoder{data: data}
+	key := messageKey{sender: string(d.bytes(d.length()))}
+	key.id = d.uvarint()
+	index, count := int(d.uvarint()), int(d.uvarint())
+	switch {
+	case d.err != nil || count < 2 || index < 0 || index >= count:
+		reject(r.port, &rejection{"unreadable", "malformed fragment"})
+		return nil
+	case count > config.Cfg.Network.MaxMessageSize/(bufSize/2)+1:
+		// Every fragment but the last fills at least half a datagram, so this many is a message over MaxMessageSize
+		reject(r.port, &rejection{"too_large", fmt.Sprintf("message of %d fragments from %q", count, key.sender)})
+		return nil
+	}
+
+	message, exists := r.pending[key]
+	if !exists {
+		if len(r.pending) >= maxPendingMessages {
+			r.dropOldest()
+		}
+		message = &partialMessage{parts: make([][]byte, count), started: time.Now()}
+		r.pending[key] = message
+	}
+	if len(message.parts) != count {
+		reject(r.port, &rejection{"unreadable", fmt.Sprintf("fragment counts differ in a message from %q", key.sender)})
+		delete(r.pending, key)
+		return nil
+	}
+	if message.parts[index] != nil {
+		return nil // A duplicated datagram
+	}
+	message.parts[index] = append([]byte(nil), d.data...)
+	message.received++
+	message.size += len(d.data)
+	if message.size > config.Cfg.Network.MaxMessageSize {
+		reject(r.port, &rejection{"too_large", fmt.Sprintf("message of more than %d bytes from %q", config.Cfg.Network.MaxMessageSize, key.sender)})
+		delete(r.pending, key)
+		return nil
+	}
+	if message.received < count {
+		return nil
+	}
+
+	delete(r.pending, key)
+	packet := make([]byte, 0, message.size)
+	for _, part := range message.parts {
+		packet = append(packet, part...)
+	}
+	return packet
+}
+
+// Drops the messages that have waited longer than Network.FragmentTimeout for
+// their other fragments
+func (r *reassembler) expire() {
+	for key, message := range r.pending {
+		if time.Since(message.started) > config.Cfg.Network.FragmentTimeout.Duration {
+			delete(r.pending, key)
+			r.dropped(key, message)
+		}
+	}
+}
+
+func (r *reassembler) dropOldest() {
+	keys := make([]messageKey, 0, len(r.pending))
+	for key := range r.pending {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return r.pending[keys[i]].started.Before(r.pending[keys[j]].started) })
+	message := r.pending[keys[0]]
+	delete(r.pending, keys[0])
+	r.dropped(keys[0], message)
+}
+
+func (r *reassembler) dropped(key messageKey, message *partialMessage) {
+	metrics.MessagesRejected.Inc("incomplete")
+	log.Debug("Dropped incomplete message", "port", r.port, "sender", key.sender, "received", message.received, "fragments", len(message.parts))
+}
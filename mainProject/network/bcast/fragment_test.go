@@ -0,0 +1,248 @@
+package bcast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mainProject/config"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// Restores the network configuration once the test is done, so it can change it
+func keepNetworkConfig(t *testing.T) {
+	t.Helper()
+	saved := config.Cfg.Network
+	t.Cleanup(func() { config.Cfg.Network = saved })
+}
+
+func randomPacket(size int) []byte {
+	packet := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(packet)
+	return packet
+}
+
+func TestFragmentNeedsVersion4(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   int
+		size      int
+		wantCount int // 0 if the message is not sent
+	}{
+		{"fits, version 3", minFragmentVersion - 1, bufSize, 1},
+		{"too long, version 3", minFragmentVersion - 1, bufSize + 1, 0},
+		{"too long, version 1", config.LegacyProtocol, 3 * bufSize, 0},
+		{"too long, version 4", minFragmentVersion, bufSize + 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keepNetworkConfig(t)
+			config.Cfg.Network.ProtocolVersion = tt.version
+			datagrams, err := fragment(randomPacket(tt.size))
+			if tt.wantCount == 0 {
+				if err == nil {
+					t.Fatalf("sent %d datagrams that version %d cannot read", len(datagrams), tt.version)
+				}
+				return
+			}
+			if err != nil || len(datagrams) != tt.wantCount {
+				t.Fatalf("fragment = %d datagrams, %v, want %d", len(datagrams), err, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestFragmentLongerThanMaxMessageSize(t *testing.T) {
+	keepNetworkConfig(t)
+	if _, err := fragment(randomPacket(config.Cfg.Network.MaxMessageSize + 1)); err == nil {
+		t.Errorf("message longer than Network.MaxMessageSize fragmented")
+	}
+	datagrams, err := fragment(randomPacket(config.Cfg.Network.MaxMessageSize))
+	if err != nil {
+		t.Fatalf("message of Network.MaxMessageSize: %v", err)
+	}
+	for i, datagram := range datagrams {
+		if len(datagram) > bufSize {
+			t.Errorf("datagram %d holds %d bytes, more than %d", i, len(datagram), bufSize)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Reassembly
+// -----------------------------------------------------------------------------
+
+// A fragment as it reaches reassembler.add, without its magic byte
+func fragmentOf(sender string, id uint64, index, count int, part []byte) []byte {
+	f := appendString(nil, sender)
+	f = binary.AppendUvarint(f, id)
+	f = binary.AppendUvarint(f, uint64(index))
+	f = binary.AppendUvarint(f, uint64(count))
+	return append(f, part...)
+}
+
+// Adds `fragments` in order, and returns what the last one gave back. Every
+// fragment before the last must give back nothing.
+func addAll(t *testing.T, r *reassembler, fragments ...[]byte) []byte {
+	t.Helper()
+	for i, f := range fragments[:len(fragments)-1] {
+		if packet := r.add(f); packet != nil {
+			t.Fatalf("fragment %d of %d gave back a packet of %d bytes", i, len(fragments), len(packet))
+		}
+	}
+	return r.add(fragments[len(fragments)-1])
+}
+
+func TestReassembleShuffled(t *testing.T) {
+	for _, size := range []int{bufSize + 1, 5000, 20000} {
+		packet := randomPacket(size)
+		datagrams, err := fragment(packet)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		fragments := make([][]byte, len(datagrams))
+		for i, datagram := range datagrams {
+			if datagram[0] != fragmentMagic {
+				t.Fatalf("%d bytes: datagram %d starts with %#x, want the fragment magic", size, i, datagram[0])
+			}
+			fragments[i] = datagram[1:]
+		}
+		rand.New(rand.NewSource(int64(size))).Shuffle(len(fragments), func(i, j int) {
+			fragments[i], fragments[j] = fragments[j], fragments[i]
+		})
+
+		r := newReassembler(0)
+		if got := addAll(t, r, fragments...); !bytes.Equal(got, packet) {
+			t.Errorf("%d bytes in %d fragments reassembled to %d bytes that differ", size, len(fragments), len(got))
+		}
+		if len(r.pending) != 0 {
+			t.Errorf("%d bytes: %d messages still pending after reassembly", size, len(r.pending))
+		}
+	}
+}
+
+func TestReassembleDuplicates(t *testing.T) {
+	r := newReassembler(0)
+	first, second := fragmentOf("elevator_2", 1, 0, 2, []byte("ab")), fragmentOf("elevator_2", 1, 1, 2, []byte("cd"))
+	if got := addAll(t, r, first, first, first, second); string(got) != "abcd" {
+		t.Fatalf("reassembled %q with duplicated fragments, want abcd", got)
+	}
+	if got := r.add(second); got != nil {
+		t.Errorf("late duplicate gave back %q again", got)
+	}
+}
+
+func TestReassembleKeepsSendersApart(t *testing.T) {
+	r := newReassembler(0)
+	got := addAll(t, r,
+		fragmentOf("elevator_2", 1, 0, 2, []byte("ab")),
+		fragmentOf("elevator_3", 1, 1, 2, []byte("xy")), // Same message ID from another sender
+		fragmentOf("elevator_2", 1, 1, 2, []byte("cd")),
+	)
+	if string(got) != "abcd" {
+		t.Errorf("reassembled %q, want abcd", got)
+	}
+}
+
+func TestReassembleRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name      string
+		fragments [][]byte
+	}{
+		{"index out of range", [][]byte{fragmentOf("elevator_2", 1, 2, 2, []byte("ab"))}},
+		{"single fragment", [][]byte{fragmentOf("elevator_2", 1, 0, 1, []byte("ab"))}},
+		{"truncated header", [][]byte{fragmentOf("elevator_2", 1, 0, 2, nil)[:12]}},
+		{"counts differ", [][]byte{
+			fragmentOf("elevator_2", 1, 0, 3, []byte("ab")),
+			fragmentOf("elevator_2", 1, 1, 2, []byte("cd")),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(0)
+			if got := addAll(t, r, tt.fragments...); got != nil {
+				t.Fatalf("gave back %q", got)
+			}
+			if len(r.pending) != 0 {
+				t.Errorf("%d messages pending, want the malformed one dropped", len(r.pending))
+			}
+		})
+	}
+}
+
+func TestReassembleCountsDifferDropsMessage(t *testing.T) {
+	r := newReassembler(0)
+	addAll(t, r, fragmentOf("elevator_2", 1, 0, 3, []byte("ab")), fragmentOf("elevator_2", 1, 1, 2, []byte("cd")))
+	// The rest of the message with the first count starts over, so it never completes
+	if got := addAll(t, r, fragmentOf("elevator_2", 1, 1, 3, []byte("cd")), fragmentOf("elevator_2", 1, 2, 3, []byte("ef"))); got != nil {
+		t.Errorf("gave back %q after the fragment counts differed", got)
+	}
+}
+
+func TestReassembleLongerThanMaxMessageSize(t *testing.T) {
+	keepNetworkConfig(t)
+	config.Cfg.Network.MaxMessageSize = 2 * bufSize
+	part := randomPacket(bufSize - 20)
+
+	tests := []struct {
+		name      string
+		fragments [][]byte
+	}{
+		{"too many fragments", [][]byte{fragmentOf("elevator_2", 1, 0, 6, part)}},
+		{"too many bytes", [][]byte{
+			fragmentOf("elevator_2", 1, 0, 3, part),
+			fragmentOf("elevator_2", 1, 1, 3, part),
+			fragmentOf("elevator_2", 1, 2, 3, part),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(0)
+			if got := addAll(t, r, tt.fragments...); got != nil {
+				t.Fatalf("gave back %d bytes, more than Network.MaxMessageSize %d", len(got), config.Cfg.Network.MaxMessageSize)
+			}
+			if len(r.pending) != 0 {
+				t.Errorf("%d messages pending, want the long one dropped", len(r.pending))
+			}
+		})
+	}
+}
+
+func TestReassembleExpires(t *testing.T) {
+	keepNetworkConfig(t)
+	r := newReassembler(0)
+	r.add(fragmentOf("elevator_2", 1, 0, 2, []byte("ab")))
+	r.pending[messageKey{"elevator_2", 1}].started = time.Now().Add(-config.Cfg.Network.FragmentTimeout.Duration - time.Millisecond)
+	r.add(fragmentOf("elevator_2", 2, 0, 2, []byte("xy")))
+
+	if _, exists := r.pending[messageKey{"elevator_2", 1}]; exists {
+		t.Fatalf("message still pending after Network.FragmentTimeout")
+	}
+	if _, exists := r.pending[messageKey{"elevator_2", 2}]; !exists {
+		t.Fatalf("message within Network.FragmentTimeout dropped")
+	}
+	if got := r.add(fragmentOf("elevator_2", 1, 1, 2, []byte("cd"))); got != nil {
+		t.Errorf("expired message completed as %q by a late fragment", got)
+	}
+}
+
+func TestReassembleDropsOldestBeyondMaxPending(t *testing.T) {
+	r := newReassembler(0)
+	started := time.Now()
+	for id := uint64(0); id <= maxPendingMessages; id++ {
+		r.add(fragmentOf("elevator_2", id, 0, 2, []byte("ab")))
+		if message, exists := r.pending[messageKey{"elevator_2", id}]; exists {
+			message.started = started.Add(time.Duration(id) * time.Millisecond) // In the order added, however coarse the clock
+		}
+	}
+
+	if len(r.pending) != maxPendingMessages {
+		t.Fatalf("%d messages pending, want at most %d", len(r.pending), maxPendingMessages)
+	}
+	if _, exists := r.pending[messageKey{"elevator_2", 0}]; exists {
+		t.Errorf("oldest message kept beyond %d pending messages", maxPendingMessages)
+	}
+	if got := r.add(fragmentOf("elevator_2", maxPendingMessages, 1, 2, []byte("cd"))); string(got) != "abcd" {
+		t.Errorf("newest message reassembled as %q, want abcd", got)
+	}
+}
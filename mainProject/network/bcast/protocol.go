@@ -0,0 +1,245 @@
+package bcast
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mainProject/config"
+	"mainProject/metrics"
+	"reflect"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// Protocol versions and message types
+// -----------------------------------------------------------------------------
+// Every message is sent in an envelope naming the protocol version of the sender,
+// the oldest version that can read the message, and the registered name of its
+// type. The name is part of the protocol, so a Go type can be renamed freely.
+//
+// Compatibility rules for changing the protocol:
+//   - Adding a message type is compatible, older readers count and drop it.
+//   - Adding a field to a message is compatible. Raise config.CurrentProtocol,
+//     older readers ignore the field and newer readers see its zero value.
+//   - Renaming or removing a field, or changing what it means, is not. Raise
+//     config.CurrentProtocol and set minReadableVersion to it.
+//   - Messages of versions older than minCompatibleVersion are rejected. Raise it
+//     once no elevator of such a version is left.
+//
+// A rolling upgrade sends the old version (Network.ProtocolVersion) until every
+// elevator is upgraded, then the configuration is changed to the current one.
+// Version 1 is the envelope used before versions existed, tagged with the Go
+// type name, so an upgraded elevator can run next to elevators that never had
+// versions. Version 3 added the binary codec (see codec.go), whose packets
+// start with binaryMagic and can only be read from version 3. Version 4 added
+// fragments for messages longer than a datagram (see fragment.go), version 5
+// signed messages (see auth.go), version 6 named reliable messages by sender,
+// incarnation and stream (see communication/reliable.go), version 7 added the
+// hall order states to the statuses (see communication/hallOrders.go), and
+// version 8 signed the peer heartbeats (see auth.go).
+
+const (
+	minCompatibleVersion = config.LegacyProtocol // Oldest version still read
+	minReadableVersion   = 2                     // Oldest version that can read the JSON messages this version sends
+	minBinaryVersion     = 3                     // Oldest version that can read the binary codec
+)
+
+// First byte of a packet in the binary codec. JSON packets start with '{'.
+const binaryMagic = 0xB1
+
+// Since version 2. In the binary codec the fields are written in order, and the
+// payload takes the rest of the packet.
+type envelope struct {
+	Version    int
+	MinVersion int
+	Type       string // Registered name of the message type
+	Sender     string // ID of the sending elevator, for logging
+	JSON       []byte // Payload, encoded with `codec`
+	codec      Codec
+}
+
+// Version 1, identified by a TypeId and no Version
+type legacyEnvelope struct {
+	TypeId string // Go type name
+	JSON   []byte
+}
+
+var (
+	registeredNames = make(map[reflect.Type]string)
+	registeredTypes = make(map[string]reflect.Type)
+	legacyNames     = make(map[string]string) // Go type name to registered name, for version 1
+	registryMutex   sync.Mutex
+)
+
+// Registers the wire name of the type of `value`. Every type sent or received
+// with bcast must be registered, usually from an init function of the package
+// declaring it. Panics if the name or the type is already registered otherwise.
+func Register(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if existing, exists := registeredTypes[name]; exists && existing != t {
+		panic(fmt.Sprintf("Message type name %q is registered for both '%s' and '%s'", name, existing, t))
+	}
+	if existing, exists := registeredNames[t]; exists && existing != name {
+		panic(fmt.Sprintf("Message type '%s' is registered as both %q and %q", t, existing, name))
+	}
+	registeredNames[t] = name
+	registeredTypes[name] = t
+	legacyNames[t.String()] = name
+}
+
+// The registered name of `t`, or "" if it is not registered
+func registeredName(t reflect.Type) string {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	return registeredNames[t]
+}
+
+func isRegistered(name string) bool {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	_, exists := registeredTypes[name]
+	return exists
+}
+
+// Encodes `value` of a registered type with `codec`, in the envelope of the
+// configured protocol version
+func Pack(codec Codec, value interface{}) ([]byte, error) {
+	t := reflect.TypeOf(value)
+	name := registeredName(t)
+	if name == "" {
+		return nil, fmt.Errorf("message type '%s' is not registered", t)
+	}
+	payload, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	version := config.Cfg.Network.ProtocolVersion
+	if codec == Binary {
+		data := []byte{binaryMagic}
+		data = binary.AppendUvarint(data, uint64(version))
+		data = binary.AppendUvarint(data, minBinaryVersion)
+		data = appendString(data, name)
+		data = appendString(data, config.LocalID)
+		return append(data, payload...), nil
+	}
+	if version == config.LegacyProtocol {
+		return json.Marshal(legacyEnvelope{TypeId: t.String(), JSON: payload})
+	}
+	return json.Marshal(envelope{
+		Version:    version,
+		MinVersion: minReadableVersion,
+		Type:       name,
+		Sender:     config.LocalID,
+		JSON:       payload,
+	})
+}
+
+// Decodes a packet made by Pack into `value`, which must point to a value of
+// the registered type the packet holds
+func Unpack(data []byte, value interface{}) error {
+	e, err := decodeEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if name := registeredName(reflect.TypeOf(value).Elem()); name != e.Type {
+		return fmt.Errorf("packet holds a %q message, not %q", e.Type, name)
+	}
+	return e.codec.Unmarshal(e.JSON, value)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// A received message that cannot be used
+type rejection struct {
+	reason string // Short label, used for the metric
+	detail string
+}
+
+func (r *rejection) Error() string {
+	return r.reason + ": " + r.detail
+}
+
+// Unwraps a received envelope of any readable version. Returns the envelope
+// with the registered type name, or a *rejection.
+func decodeEnvelope(data []byte) (envelope, error) {
+	if len(data) > 0 && data[0] == binaryMagic {
+		return decodeBinaryEnvelope(data[1:])
+	}
+	var raw struct {
+		envelope
+		TypeId string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return envelope{}, &rejection{"unreadable", "not a message envelope"}
+	}
+	e := raw.envelope
+	if e.Version == 0 && raw.TypeId != "" {
+		registryMutex.Lock()
+		name, known := legacyNames[raw.TypeId]
+		registryMutex.Unlock()
+		if !known {
+			return envelope{}, &rejection{"unknown_type", fmt.Sprintf("version %d type %q", config.LegacyProtocol, raw.TypeId)}
+		}
+		e.Version, e.MinVersion, e.Type = config.LegacyProtocol, config.LegacyProtocol, name
+	}
+	e.codec = JSON
+	return e, checkEnvelope(e)
+}
+
+func decodeBinaryEnvelope(data []byte) (envelope, error) {
+	d := decoder{data: data}
+	e := envelope{codec: Binary}
+	e.Version = int(d.uvarint())
+	e.MinVersion = int(d.uvarint())
+	e.Type = string(d.bytes(d.length()))
+	e.Sender = string(d.bytes(d.length()))
+	if d.err != nil {
+		return envelope{}, &rejection{"unreadable", "truncated binary envelope"}
+	}
+	e.JSON = d.data
+	return e, checkEnvelope(e)
+}
+
+// Checks that an envelope can be read by this version
+func checkEnvelope(e envelope) error {
+	switch {
+	case e.Version == 0:
+		return &rejection{"unreadable", "no protocol version"}
+	case e.Version < minCompatibleVersion:
+		return &rejection{"version_too_old", fmt.Sprintf("version %d from %q, the oldest read is %d", e.Version, e.Sender, minCompatibleVersion)}
+	case e.MinVersion > config.CurrentProtocol:
+		return &rejection{"version_too_new", fmt.Sprintf("version %d from %q needs at least version %d, this is %d", e.Version, e.Sender, e.MinVersion, config.CurrentProtocol)}
+	case !isRegistered(e.Type):
+		return &rejection{"unknown_type", fmt.Sprintf("version %d type %q from %q", e.Version, e.Type, e.Sender)}
+	}
+	return nil
+}
+
+var (
+	reportedRejections = make(map[string]bool)
+	rejectionsMutex    sync.Mutex
+)
+
+// Counts a rejected message, and logs each kind of rejection once per port so a
+// node of another version does not flood the log
+func reject(port int, err error) {
+	var r *rejection
+	if !errors.As(err, &r) {
+		r = &rejection{"unreadable", "payload does not match its type"}
+	}
+	metrics.MessagesRejected.Inc(r.reason)
+	key := fmt.Sprintf("%d %s", port, r.Error())
+	rejectionsMutex.Lock()
+	defer rejectionsMutex.Unlock()
+	if reportedRejections[key] {
+		return
+	}
+	reportedRejections[key] = true
+	log.Warn("Rejected message", "port", port, "reason", r.reason, "detail", r.detail)
+}
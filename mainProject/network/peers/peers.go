@@ -2,6 +2,7 @@ package peers
 
 import (
 	"fmt"
+	"mainProject/network/bcast"
 	"mainProject/network/conn"
 	"net"
 	"sort"
@@ -15,10 +16,8 @@ type PeerUpdate struct {
 	Lost  []string
 }
 
-const interval = 15 * time.Millisecond
-const timeout = 2000 * time.Millisecond
-
 func Transmitter(port int, id string, transmitEnable <-chan bool) {
+	interval := config.Cfg.Network.PeerInterval.Duration
 
 	conn := conn.DialBroadcastUDP(port)
 	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
@@ -31,7 +30,7 @@ func Transmitter(port int, id string, transmitEnable <-chan bool) {
 		}
 		if enable {
 			for i := 0; i < 3; i++ {
-				conn.WriteTo([]byte(id), addr)
+				conn.WriteTo(bcast.SignHeartbeat(id), addr) // Signed one by one, as each copy needs its own nonce
 				time.Sleep(5 * time.Millisecond)
 			}
 		}
@@ -39,10 +38,13 @@ func Transmitter(port int, id string, transmitEnable <-chan bool) {
 }
 
 func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
+	interval := config.Cfg.Network.PeerInterval.Duration
+	timeout := config.Cfg.Network.PeerTimeout.Duration
 
 	var buf [1024]byte
 	var p PeerUpdate
 	lastSeen := make(map[string]time.Time)
+	heartbeats := bcast.NewHeartbeatVerifier(port)
 
 	conn := conn.DialBroadcastUDP(port)
 
@@ -52,7 +54,7 @@ func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
 		conn.SetReadDeadline(time.Now().Add(interval))
 		n, _, _ := conn.ReadFrom(buf[0:])
 
-		id := string(buf[:n])
+		id := heartbeats.Verify(buf[:n]) // "" on a read timeout or a dropped heartbeat
 
 		// Adding new connection
 		p.New = []string{}
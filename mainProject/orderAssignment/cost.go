@@ -5,22 +5,23 @@ import (
 	"mainProject/config"
 	"mainProject/elevio"
 	"mainProject/singleElevator"
+	"time"
 )
 
 const(
-	travelTime = 3 //Seconds
+	travelTime = 3 * time.Second
 )
 
-func cost(elevator communication.ElevatorStatus, order elevio.ButtonEvent) int{
+func cost(elevator communication.ElevatorStatus, order elevio.ButtonEvent) time.Duration{
 	//Making an elevator object from the passed ElevatorStatus argument
 	var e config.Elevator
 	e.Floor = elevator.Floor
 	e.Direction = elevator.Direction
-	e.Queue = elevator.Queue
+	e.Queue = elevator.Queue.Clone()
 	e.State = elevator.State
 	e.Queue[order.Floor][order.Button] = true
 
-	timeToCompleteOrders := 0
+	var timeToCompleteOrders time.Duration
 
 	//switch with current state of elevator, initial time assessment
 	switch e.State {
@@ -114,7 +115,7 @@ func ChooseDirection(e config.Elevator) elevio.MotorDirection {
 }
 
 func HasOrdersAbove(e config.Elevator) bool {
-	for f := e.Floor + 1; f < config.NumFloors; f++ {
+	for f := e.Floor + 1; f < len(e.Queue); f++ {
 		for b := 0; b < config.NumButtons; b++ {
 			if e.Queue[f][b] {
 				return true
@@ -0,0 +1,72 @@
+package orderAssignment
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"sort"
+)
+
+// -----------------------------------------------------------------------------
+// World-view merge after a partition heals
+// -----------------------------------------------------------------------------
+// While the network is split, each side elects its own master and assigns hall
+// calls on its own. When peers join, the master waits until they follow it, then
+// takes the union of the hall calls in all queues, gives every call held by more
+// than one elevator to a single elevator, and re-syncs the hall lamps on all nodes.
+
+// Masters other than this elevator that some elevator on the network follows
+func rivalMasters(elevatorStatuses map[string]communication.ElevatorStatus) []string {
+	rivals := make(map[string]bool)
+	for _, status := range elevatorStatuses {
+		if status.MasterID == "" || status.MasterID == config.LocalID {
+			continue
+		}
+		if _, alive := elevatorStatuses[status.MasterID]; alive {
+			rivals[status.MasterID] = true
+		}
+	}
+	return sortedKeys(rivals)
+}
+
+// Whether all `joined` elevators follow this elevator, or have left again
+func joinedPeersFollow(joined map[string]bool, elevatorStatuses map[string]communication.ElevatorStatus) bool {
+	for id := range joined {
+		status, exists := elevatorStatuses[id]
+		if exists && status.MasterID != config.LocalID {
+			return false
+		}
+	}
+	return true
+}
+
+// The elevators holding each hall call, in ID order
+func hallCallHolders(elevatorStatuses map[string]communication.ElevatorStatus) map[elevio.ButtonEvent][]string {
+	holders := make(map[elevio.ButtonEvent][]string)
+	for id, status := range elevatorStatuses {
+		if len(status.Queue) != config.NumFloors {
+			continue
+		}
+		for floor := range status.Queue {
+			for button := elevio.BT_HallUp; button <= elevio.BT_HallDown; button++ {
+				if status.Queue[floor][button] {
+					call := elevio.ButtonEvent{Floor: floor, Button: button}
+					holders[call] = append(holders[call], id)
+				}
+			}
+		}
+	}
+	for _, ids := range holders {
+		sort.Strings(ids)
+	}
+	return holders
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,72 +3,265 @@ package orderAssignment
 import (
 	"mainProject/config"
 	"mainProject/elevio"
-	"mainProject/masterElection"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/metrics"
 	"mainProject/communication"
+	"mainProject/singleElevator"
 	"fmt"
+	"math"
+	"slices"
+	"time"
 )
 
+var log = logging.For("orderAssignment")
+
 func RunOrderAssignment(elevatorStatusesChan chan map[string]communication.ElevatorStatus, masterChan chan string, lostPeerChan chan string, newPeerChan chan string, hallCallChan chan elevio.ButtonEvent, assignedHallCallChan chan elevio.ButtonEvent, orderStatusChan chan communication.OrderStatusMessage, txAckChan chan communication.AckMessage) {
+	if config.Cfg.AssignmentMode == config.PeerAssignment {
+		go runPeerAssignment(elevatorStatusesChan, lostPeerChan, newPeerChan, hallCallChan, assignedHallCallChan)
+		return
+	}
 
 	go func() {
 		var latestElevatorStatuses map[string]communication.ElevatorStatus
+		unassignedHallCalls := make(unassignedCalls) // Hall calls waiting for an available elevator, see outstanding.go
+		var hallCallsWaitingForMaster []elevio.ButtonEvent
+		var lostMasters []string // Lost masters whose hall calls the next master must reassign
+		lastMasterID := ""
+		joinedPeers := make(map[string]bool) // Peers that joined since the last merge of world views
+		var joinedSince time.Time
+		seenRivals := make(map[string]bool)  // Other masters already reported as a split brain
+		litLamps := make(map[elevio.ButtonEvent]bool)
+		orphanedSince := make(map[elevio.ButtonEvent]time.Time) // Confirmed hall calls no elevator was seen holding
+		failedAssignments := make(chan failedAssignment, 10)
+		outstanding := make(outstandingAssignments) // Hall calls sent to elevators not yet seen holding them, see outstanding.go
+
+		// Gives a hall call to the best available elevator, or keeps it until one becomes available
+		assignHallCall := func(hallCall elevio.ButtonEvent, excludedElevators ...string) {
+			callLog := log.With("floor", hallCall.Floor, "button", hallCall.Button, "order", logging.OrderID(hallCall))
+			bestElevator := findBestElevator(hallCall, latestElevatorStatuses, excludedElevators...)
+			if bestElevator == "" {
+				callLog.Warn("No available elevator for hall call, keeping it until one is available")
+				journal.RecordCall(journal.HallCallUnassigned, hallCall, logging.OrderID(hallCall), "")
+				unassignedHallCalls.add(hallCall, excludedElevators)
+				return
+			}
+			delete(unassignedHallCalls, hallCall)
+			orphanedSince[hallCall] = time.Now() // Gives the assignment time to arrive before the call counts as orphaned
+			metrics.HallCallsAssigned.Inc(bestElevator)
+			journal.RecordCall(journal.HallCallAssigned, hallCall, logging.OrderID(hallCall), bestElevator)
+			if bestElevator == config.LocalID {
+				delete(outstanding, hallCall)
+				assignedHallCallChan <- hallCall
+				callLog.Info("Assigned hall call to local elevator")
+			} else {
+				outstanding.add(hallCall, bestElevator, excludedElevators)
+				onFailed := func() { failedAssignments <- failedAssignment{call: hallCall, target: bestElevator} }
+				go communication.SendAssignment(bestElevator, hallCall.Floor, hallCall.Button, communication.DeliveryCallbacks{OnFailed: onFailed})
+				callLog.Info("Sent hall assignment", "elevator", bestElevator)
+			}
+		}
+
+		// Gives an outstanding hall call to another elevator, as its target did not take it
+		reassignOutstanding := func(call elevio.ButtonEvent, reason string) {
+			target := outstanding[call].target
+			log.Warn("Assigned elevator did not take hall call, reassigning it", "floor", call.Floor, "button", call.Button, "elevator", target, "reason", reason, "order", logging.OrderID(call))
+			journal.Record(journal.Event{Kind: journal.HallCallReassigned, Call: &call, OrderID: logging.OrderID(call), Peer: target, Detail: reason})
+			metrics.HallCallsReassigned.Inc(reason)
+			assignHallCall(call, outstanding.failed(call)...)
+		}
+
+		// Reconciles the hall calls of both sides of a healed partition, see merge.go
+		mergeWorldViews := func() {
+			holders := hallCallHolders(latestElevatorStatuses)
+			revoked := 0
+			for call, holderIDs := range holders {
+				if len(holderIDs) < 2 {
+					continue
+				}
+				callLog := log.With("floor", call.Floor, "button", call.Button, "order", logging.OrderID(call), "holders", holderIDs)
+				keeper := findBestElevator(call, latestElevatorStatuses, "")
+				keeperHoldsCall := false
+				for _, id := range holderIDs {
+					keeperHoldsCall = keeperHoldsCall || id == keeper
+				}
+				if keeper == "" {
+					keeper, keeperHoldsCall = holderIDs[0], true // No elevator is available, so the call stays with one holder
+				}
+				for _, id := range holderIDs {
+					if keeperHoldsCall && id == keeper {
+						continue
+					}
+					revoked++
+					metrics.HallCallsRevoked.Inc()
+					if id == config.LocalID {
+						singleElevator.RevokeHallCall(call)
+					} else {
+						go communication.SendRevocation(id, call)
+					}
+				}
+				callLog.Info("Hall call held by several elevators", "keeper", keeper)
+				if !keeperHoldsCall {
+					assignHallCall(call, "")
+				}
+			}
+
+			// The lamps need no re-sync, as the hall order states of both sides merge on their own
+			outstanding := len(holders) + len(unassignedHallCalls)
+			log.Info("Merged world views", "hall_calls", outstanding, "revoked", revoked)
+			journal.Record(journal.Event{Kind: journal.WorldViewsMerged, Detail: fmt.Sprintf("%d hall calls, %d revoked", outstanding, revoked)})
+		}
 
 		for {
 			select {
 			case updatedStatuses := <-elevatorStatusesChan:
 				latestElevatorStatuses = updatedStatuses 
+				confirmed := syncHallLamps(litLamps)
+				if config.MasterID() != config.LocalID {
+					// The master merges, and a master that stepped down leaves it to the new one
+					joinedPeers = make(map[string]bool)
+					seenRivals = make(map[string]bool)
+					outstanding = make(outstandingAssignments) // The new master finds the calls orphaned if they are lost
+				} else {
+					for _, rival := range rivalMasters(updatedStatuses) {
+						if !seenRivals[rival] {
+							seenRivals[rival] = true
+							log.Warn("Split brain: another master is on the network", "rival", rival, "term", config.MasterTerm())
+							journal.Record(journal.Event{Kind: journal.SplitBrainDetected, Peer: rival, Detail: fmt.Sprintf("term %d", config.MasterTerm())})
+							metrics.SplitBrains.Inc()
+						}
+					}
+					settled := joinedPeersFollow(joinedPeers, updatedStatuses) || time.Since(joinedSince) > 2*config.Cfg.Network.MasterTimeout.Duration
+					if len(joinedPeers) > 0 && settled {
+						mergeWorldViews()
+						joinedPeers = make(map[string]bool)
+						seenRivals = make(map[string]bool)
+					}
+				}
+				if config.MasterID() == config.LocalID {
+					for _, call := range outstanding.settle(updatedStatuses) {
+						reassignOutstanding(call, targetTimeout)
+					}
+					// A lit lamp promises that an elevator comes, also when the raw hall call or the assignment was lost
+					refreshLocalStatus(updatedStatuses) // A call just taken by the local elevator is not orphaned
+					for _, call := range orphanedHallCalls(confirmed, updatedStatuses, unassignedHallCalls, orphanedSince) {
+						log.Warn("Confirmed hall call is held by no elevator, assigning it", "floor", call.Floor, "button", call.Button, "order", logging.OrderID(call))
+						assignHallCall(call, "")
+					}
+				}
+				if config.MasterID() == config.LocalID {
+					for _, hallCall := range sortedCalls(unassignedHallCalls) {
+						assignHallCall(hallCall, unassignedHallCalls.excluded(hallCall)...)
+					}
+				}
 
 			case newMaster := <-masterChan:
-				config.MasterID = newMaster 
+				// The election has already updated config.MasterID()
+				if newMaster == "" {
+					continue
+				}
+				if newMaster == config.LocalID {
+					// Take over the hall calls of masters lost before this elevator was elected
+					for _, lostMaster := range lostMasters {
+						for _, order := range getReassignedHallOrders(lostMaster, communication.GetBackupState()) {
+							log.Info("Reassigning hall call", "floor", order.Floor, "button", order.Button, "from", lostMaster, "order", logging.OrderID(order))
+							assignHallCall(order, lostMaster)
+						}
+					}
+					for _, hallCall := range hallCallsWaitingForMaster {
+						assignHallCall(hallCall, "")
+					}
+				} else {
+					if lastMasterID == config.LocalID {
+						// Stepped down for another master, which takes over the calls no elevator could take
+						hallCallsWaitingForMaster = append(hallCallsWaitingForMaster, sortedCalls(unassignedHallCalls)...)
+						unassignedHallCalls = make(unassignedCalls)
+					}
+					for _, hallCall := range hallCallsWaitingForMaster {
+						go communication.SendRawHallCall(hallCall)
+						log.Info("Forwarded hall call to master", "master", newMaster, "floor", hallCall.Floor, "button", hallCall.Button, "order", logging.OrderID(hallCall))
+					}
+				}
+				lostMasters = nil
+				hallCallsWaitingForMaster = nil
+				lastMasterID = newMaster
 
 			case lostElevator := <-lostPeerChan:
-				if lostElevator == config.MasterID {
-					masterElection.RunMasterElection(elevatorStatusesChan, masterChan)
-					newMasterID := <-masterChan
-					config.MasterID = newMasterID
+				if lostElevator == lastMasterID && lostElevator != config.LocalID && config.MasterID() != config.LocalID {
+					// Its hall calls are reassigned by whoever wins the election
+					lostMasters = append(lostMasters, lostElevator)
+				}
+				if config.MasterID() == config.LocalID {
+					for _, call := range outstanding.sentTo(lostElevator) {
+						reassignOutstanding(call, targetLost)
+					}
 				}
-				if config.MasterID == config.LocalID && latestElevatorStatuses != nil {
+				if config.MasterID() == config.LocalID && latestElevatorStatuses != nil {
 					reassignedHallOrders := getReassignedHallOrders(lostElevator, latestElevatorStatuses)
 					for _, order := range reassignedHallOrders {
-						bestElevator := findBestElevator(order, latestElevatorStatuses, lostElevator) 
-						fmt.Printf("Reassigned order at floor %d to %s\n\n", order.Floor, bestElevator)
-						if bestElevator == config.LocalID {
-							assignedHallCallChan <- order
-						} else {
-							communication.SendAssignment(bestElevator, order.Floor, order.Button)
-						}
+						log.Info("Reassigning hall call", "floor", order.Floor, "button", order.Button, "from", lostElevator, "order", logging.OrderID(order))
+						assignHallCall(order, lostElevator)
 					}
 				}
 			case newElevator := <-newPeerChan:
-				masterElection.RunMasterElection(elevatorStatusesChan, masterChan)
-				if config.MasterID == config.LocalID && latestElevatorStatuses != nil {
+				if len(joinedPeers) == 0 {
+					joinedSince = time.Now()
+				}
+				joinedPeers[newElevator] = true
+				if config.MasterID() == config.LocalID && latestElevatorStatuses != nil {
 					backupStates := communication.GetBackupState()
 					reassignCabCalls := getReassignedCabCalls(newElevator, backupStates)
 					for _, call := range reassignCabCalls {
-						fmt.Printf("Reassigning cab call at floor %d to %s\n\n", call.Floor, newElevator)
-						communication.SendAssignment(newElevator, call.Floor, call.Button)
+						log.Info("Restoring cab call", "floor", call.Floor, "elevator", newElevator)
+						go communication.SendAssignment(newElevator, call.Floor, call.Button, communication.DeliveryCallbacks{})
 					}
 				}
+			case failed := <-failedAssignments:
+				// Settled calls, and calls already given to another elevator, are not outstanding for the target
+				if a, exists := outstanding[failed.call]; !exists || a.target != failed.target || holdsHallCall(latestElevatorStatuses[failed.target], failed.call) {
+					continue
+				}
+				reassignOutstanding(failed.call, deliveryFailed)
+
 			case hallCall := <-hallCallChan: 
-				if config.MasterID == config.LocalID {
-					bestElevator := findBestElevator(hallCall, latestElevatorStatuses, "") // Passing "" on excludeElevator when normally calling AssignHallOrder		
-					
-					if bestElevator == config.LocalID {
-						assignedHallCallChan <- hallCall
-						fmt.Printf("Assigned hall call to local elevator at floor %d\n\n", hallCall.Floor)
-					} else {
-						go communication.SendAssignment(bestElevator, hallCall.Floor, hallCall.Button)
-						fmt.Printf("Sent hall assignment to elevator: %s\n\n", bestElevator)
-					}
+				communication.PressHallOrder(hallCall) // Does nothing for a call handed back, as it is already known
+				if config.MasterID() == "" {
+					log.Info("No master elected, keeping hall call until there is one", "floor", hallCall.Floor, "button", hallCall.Button, "order", logging.OrderID(hallCall))
+					hallCallsWaitingForMaster = append(hallCallsWaitingForMaster, hallCall)
+				} else if config.MasterID() == config.LocalID {
+					// A hall call handed back comes after the local status that made the elevator unavailable
+					refreshLocalStatus(latestElevatorStatuses)
+					assignHallCall(hallCall, unassignedHallCalls.excluded(hallCall)...) // A call pressed again while waiting still leaves out the elevators that failed it
 				} else {
 					go communication.SendRawHallCall(hallCall)
-					fmt.Printf("Forwarded hall call to master: %s\n\n", config.MasterID)
+					log.Info("Forwarded hall call to master", "master", config.MasterID(), "floor", hallCall.Floor, "button", hallCall.Button, "order", logging.OrderID(hallCall))
 				}
 			}
 		}
 	}()
 }
 
+// An assignment given up after its last retry
+type failedAssignment struct {
+	call   elevio.ButtonEvent
+	target string
+}
+
+// Replaces the local status in `elevatorStatuses` with the one in the shared
+// statuses, which the local elevator updates at once. The statuses sent on the
+// channel may lag behind.
+func refreshLocalStatus(elevatorStatuses map[string]communication.ElevatorStatus) {
+	if local, exists := communication.GetElevatorStatuses()[config.LocalID]; exists && elevatorStatuses != nil {
+		elevatorStatuses[config.LocalID] = local
+	}
+}
+
+// Whether the status shows the elevator holding the call, for when the
+// assignment arrived but its acks were lost
+func holdsHallCall(status communication.ElevatorStatus, call elevio.ButtonEvent) bool {
+	return len(status.Queue) == config.NumFloors && status.Queue[call.Floor][call.Button]
+}
+
 // Reassign hall orders if an elevator disconnects
 func getReassignedHallOrders(lostElevator string, elevatorStatuses map[string]communication.ElevatorStatus) []elevio.ButtonEvent{
 	reassignedOrders := []elevio.ButtonEvent{}
@@ -76,9 +269,9 @@ func getReassignedHallOrders(lostElevator string, elevatorStatuses map[string]co
 	if !exists {
 		return reassignedOrders
 	}
-	fmt.Printf("Reassigning hall calls from elevator %s...\n", lostElevator)
+	log.Info("Reassigning hall calls from lost elevator", "elevator", lostElevator)
 
-	for floor := 0; floor < config.NumFloors; floor++ {
+	for floor := range state.Queue {
 		for button := 0; button < config.NumButtons; button++ {
 			if button == int(elevio.BT_Cab) || !state.Queue[floor][button] {
 				continue
@@ -96,9 +289,9 @@ func getReassignedCabCalls(recoveredElevator string, backupElevatorStates map[st
 	if !exists {
 		return reassignedCabCalls
 	} 	
-	fmt.Printf("Restoring state: %v\n", state.Queue)
+	log.Debug("Restoring state", "elevator", recoveredElevator, "queue", state.Queue)
 
-	for floor := 0; floor < config.NumFloors; floor++ {
+	for floor := range state.Queue {
 		if state.Queue[floor][elevio.BT_Cab] {
 			reassignedCabCalls = append(reassignedCabCalls, elevio.ButtonEvent{Floor: floor, Button: elevio.BT_Cab})
 		}
@@ -106,24 +299,81 @@ func getReassignedCabCalls(recoveredElevator string, backupElevatorStates map[st
 	return reassignedCabCalls
 }
 
-// Determines the best available elevator based on cost function
-func findBestElevator(order elevio.ButtonEvent, elevatorStatuses map[string]communication.ElevatorStatus, excludeElevator string) string {
-	fmt.Printf("Available elevators: %v\n\n", elevatorStatuses)
+// Determines the best available elevator based on cost function.
+// Returns "" if no elevator is available.
+func findBestElevator(order elevio.ButtonEvent, elevatorStatuses map[string]communication.ElevatorStatus, excludedElevators ...string) string {
+	log.Debug("Finding best elevator", "statuses", elevatorStatuses)
 	bestElevator := ""
-	bestCost := 999
+	bestCost := time.Duration(math.MaxInt64)
 
 	for id, state := range elevatorStatuses {
-		if id == excludeElevator { 
+		if slices.Contains(excludedElevators, id) { 
 			continue 
 		}
+		if !state.Available {
+			log.Debug("Skipping unavailable elevator", "elevator", id)
+			continue
+		}
+		if len(state.Queue) != config.NumFloors {
+			continue // No status received from this peer yet
+		}
 		cost := cost(state, order)
-		fmt.Printf("Checking elevator %s at floor %d (cost: %d)\n", id, state.Floor, cost)
+		log.Debug("Checked elevator", "elevator", id, "floor", state.Floor, "cost", cost)
 
 		if cost < bestCost {
 			bestElevator = id
 			bestCost = cost
 		}
 	}
-	fmt.Println()
 	return bestElevator
 }
+
+// The Confirmed hall calls that no elevator has held, and the master has not
+// kept, for longer than an assignment can take to be delivered. `orphanedSince`
+// tracks when each call was first seen without a holder.
+func orphanedHallCalls(confirmed map[elevio.ButtonEvent]bool, elevatorStatuses map[string]communication.ElevatorStatus, unassignedHallCalls unassignedCalls, orphanedSince map[elevio.ButtonEvent]time.Time) []elevio.ButtonEvent {
+	held := make(map[elevio.ButtonEvent]bool)
+	for call := range hallCallHolders(elevatorStatuses) {
+		held[call] = true
+	}
+	for call := range unassignedHallCalls {
+		held[call] = true
+	}
+	var orphaned []elevio.ButtonEvent
+	for _, call := range sortedCalls(confirmed) {
+		if held[call] {
+			delete(orphanedSince, call)
+			continue
+		}
+		since, seen := orphanedSince[call]
+		if !seen {
+			orphanedSince[call] = time.Now()
+			continue
+		}
+		if time.Since(since) > communication.DeliveryTimeout()+config.Cfg.Network.MasterTimeout.Duration {
+			orphaned = append(orphaned, call)
+		}
+	}
+	for call := range orphanedSince {
+		if !confirmed[call] {
+			delete(orphanedSince, call)
+		}
+	}
+	return orphaned
+}
+
+// Sets the hall lamps of the local elevator to the Confirmed hall orders, and
+// returns them. `litLamps` holds the lamps as last set.
+func syncHallLamps(litLamps map[elevio.ButtonEvent]bool) map[elevio.ButtonEvent]bool {
+	confirmed := communication.GetConfirmedHallOrders()
+	for floor := 0; floor < config.NumFloors; floor++ {
+		for button := elevio.BT_HallUp; button <= elevio.BT_HallDown; button++ {
+			call := elevio.ButtonEvent{Floor: floor, Button: button}
+			if config.ButtonExists(floor, button) && confirmed[call] != litLamps[call] {
+				singleElevator.SetHallLamp(call, confirmed[call])
+				litLamps[call] = confirmed[call]
+			}
+		}
+	}
+	return confirmed
+}
@@ -0,0 +1,140 @@
+package orderAssignment
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"slices"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Outstanding assignments
+// -----------------------------------------------------------------------------
+// The master keeps every hall call it has sent to another elevator until that
+// elevator is seen holding it in its status. If the assignment is given up, the
+// target is lost, or the target does not show the call within
+// assignmentTimeout, the call is given to another elevator. The elevators that
+// already failed a call are left out when it is assigned again, and if no other
+// elevator is available the call waits with the unassigned calls, so it is
+// kept until some elevator takes it. They stay left out while it waits.
+
+// Why an outstanding assignment is given to another elevator
+const (
+	deliveryFailed = "delivery_failed"
+	targetLost     = "target_lost"
+	targetTimeout  = "timeout"
+)
+
+type outstandingAssignment struct {
+	target   string
+	sentAt   time.Time
+	excluded []string // Elevators that failed the call before the target
+}
+
+// Whether one of `holders` has taken the call. The elevators that failed it may
+// still show it in a status sent before they were lost.
+func (a *outstandingAssignment) takenBy(holders []string) bool {
+	for _, holder := range holders {
+		if !slices.Contains(a.excluded, holder) {
+			return true
+		}
+	}
+	return false
+}
+
+type outstandingAssignments map[elevio.ButtonEvent]*outstandingAssignment
+
+// Time for the target to ack an assignment and show the call in its status
+func assignmentTimeout() time.Duration {
+	return communication.DeliveryTimeout() + config.Cfg.Network.MasterTimeout.Duration
+}
+
+// Starts waiting for `target` to take the call. `excluded` are the elevators
+// left out when the call was assigned.
+func (o outstandingAssignments) add(call elevio.ButtonEvent, target string, excluded []string) {
+	o[call] = &outstandingAssignment{target: target, sentAt: time.Now(), excluded: excluded}
+}
+
+// The elevators to leave out when the call is assigned again: its target and
+// those that failed it before
+func (o outstandingAssignments) failed(call elevio.ButtonEvent) []string {
+	a, exists := o[call]
+	if !exists {
+		return nil
+	}
+	delete(o, call)
+	return append(append([]string{}, a.excluded...), a.target)
+}
+
+// Forgets the calls taken by an elevator that has not failed them, and those no
+// longer waiting to be served, then returns the calls whose target has not
+// taken them in time
+func (o outstandingAssignments) settle(elevatorStatuses map[string]communication.ElevatorStatus) []elevio.ButtonEvent {
+	holders := hallCallHolders(elevatorStatuses)
+	var expired []elevio.ButtonEvent
+	for _, call := range sortedCalls(o) {
+		state := communication.GetHallOrderState(call)
+		if o[call].takenBy(holders[call]) || (state != communication.Unconfirmed && state != communication.Confirmed) {
+			delete(o, call)
+			continue
+		}
+		if time.Since(o[call].sentAt) > assignmentTimeout() {
+			expired = append(expired, call)
+		}
+	}
+	return expired
+}
+
+// The calls waiting for `target` to take them
+func (o outstandingAssignments) sentTo(target string) []elevio.ButtonEvent {
+	var calls []elevio.ButtonEvent
+	for _, call := range sortedCalls(o) {
+		if o[call].target == target {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// -----------------------------------------------------------------------------
+// Unassigned calls
+// -----------------------------------------------------------------------------
+// A call that no available elevator could take waits until one becomes
+// available. The elevators that failed it stay left out while it waits, but
+// only for exclusionTimeout, as they may have recovered meanwhile and be the
+// only elevators left.
+
+// When each elevator that failed a waiting call may be given it again
+type unassignedCalls map[elevio.ButtonEvent]map[string]time.Time
+
+// How long an elevator that failed a call is left out of it
+func exclusionTimeout() time.Duration {
+	return 3 * assignmentTimeout()
+}
+
+// Keeps the call until an elevator is available, leaving out `excluded`.
+// Elevators already left out keep their expiry, so retrying a waiting call
+// does not extend it.
+func (u unassignedCalls) add(call elevio.ButtonEvent, excluded []string) {
+	if u[call] == nil {
+		u[call] = make(map[string]time.Time)
+	}
+	for _, id := range excluded {
+		if _, exists := u[call][id]; id != "" && !exists {
+			u[call][id] = time.Now().Add(exclusionTimeout())
+		}
+	}
+}
+
+// The elevators still left out of a waiting call
+func (u unassignedCalls) excluded(call elevio.ButtonEvent) []string {
+	var excluded []string
+	for id, until := range u[call] {
+		if time.Now().Before(until) {
+			excluded = append(excluded, id)
+		}
+	}
+	slices.Sort(excluded)
+	return excluded
+}
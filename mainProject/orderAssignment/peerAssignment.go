@@ -0,0 +1,153 @@
+package orderAssignment
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/metrics"
+	"mainProject/singleElevator"
+	"math"
+	"sort"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Peer assignment mode
+// -----------------------------------------------------------------------------
+// Every elevator runs the same assignment over the Confirmed hall orders (see
+// communication/hallOrders.go) and the same statuses, then serves only the calls
+// it gave to itself. A call is only assigned once every alive elevator has seen
+// it. There is no master, so no hall call waits for an election or for a
+// forwarded raw hall call. When the statuses change, a call may move to another
+// elevator, and the elevator that had it drops it from its queue.
+
+func runPeerAssignment(elevatorStatusesChan chan map[string]communication.ElevatorStatus, lostPeerChan chan string, newPeerChan chan string, hallCallChan chan elevio.ButtonEvent, assignedHallCallChan chan elevio.ButtonEvent) {
+	takenHallCalls := make(map[elevio.ButtonEvent]bool) // Hall calls given to the local elevator
+	litLamps := make(map[elevio.ButtonEvent]bool)
+
+	update := func() {
+		requests := syncHallLamps(litLamps)
+
+		// Only the shared statuses, so every elevator computes the same assignment. The
+		// local status is updated before a hall call is handed back, so the call is not
+		// given straight back.
+		assignments := assignHallRequests(requests, communication.GetElevatorStatuses())
+
+		for call := range takenHallCalls {
+			if assignments[call] != config.LocalID {
+				delete(takenHallCalls, call)
+				if requests[call] {
+					log.Info("Hall call moved to another elevator", "floor", call.Floor, "button", call.Button, "elevator", assignments[call], "order", logging.OrderID(call))
+				}
+				singleElevator.RevokeHallCall(call) // Does nothing if the call was served here
+			}
+		}
+		for _, call := range sortedCalls(assignments) {
+			if assignments[call] != config.LocalID || takenHallCalls[call] {
+				continue
+			}
+			takenHallCalls[call] = true
+			metrics.HallCallsAssigned.Inc(config.LocalID)
+			journal.RecordCall(journal.HallCallAssigned, call, logging.OrderID(call), config.LocalID)
+			log.Info("Taking hall call", "floor", call.Floor, "button", call.Button, "order", logging.OrderID(call))
+			assignedHallCallChan <- call
+		}
+	}
+
+	for {
+		select {
+		case <-elevatorStatusesChan:
+			update()
+
+		case <-lostPeerChan:
+			update() // Its hall calls are still Confirmed, and go to the remaining elevators
+
+		case <-newPeerChan:
+			update() // It learns the hall calls from the hall order states in our status
+
+		case hallCall := <-hallCallChan:
+			// A press, or a call the local elevator handed back as it cannot serve it
+			delete(takenHallCalls, hallCall)
+			communication.PressHallOrder(hallCall)
+			update()
+		}
+	}
+}
+
+// Gives every active hall call to an available elevator, or leaves it out if
+// there is none. Every elevator gets the same result from the same statuses:
+//
+//  1. A call that an elevator's status shows in its queue stays with it, so calls
+//     do not move back and forth while the elevators react to each other. If
+//     several elevators have it, the lowest ID keeps it.
+//  2. The other calls are taken in floor order, each given to the elevator with
+//     the lowest cost for its cab calls and the calls given to it so far. Ties
+//     go to the lowest ID.
+func assignHallRequests(requests map[elevio.ButtonEvent]bool, elevatorStatuses map[string]communication.ElevatorStatus) map[elevio.ButtonEvent]string {
+	holds := make(map[string]config.Queue) // Queues as reported, with the hall calls the elevators already serve
+	planned := make(map[string]communication.ElevatorStatus)
+	var ids []string
+	for id, status := range elevatorStatuses {
+		if !status.Available || len(status.Queue) != config.NumFloors {
+			continue
+		}
+		holds[id] = status.Queue
+		status.Queue = status.Queue.Clone()
+		for floor := range status.Queue {
+			status.Queue[floor][elevio.BT_HallUp] = false
+			status.Queue[floor][elevio.BT_HallDown] = false
+		}
+		planned[id] = status
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	assignments := make(map[elevio.ButtonEvent]string)
+	calls := sortedCalls(requests)
+	for _, call := range calls {
+		for _, id := range ids {
+			if holds[id][call.Floor][call.Button] {
+				assignments[call] = id
+				planned[id].Queue[call.Floor][call.Button] = true
+				break
+			}
+		}
+	}
+	for _, call := range calls {
+		if _, held := assignments[call]; held {
+			continue
+		}
+		best := ""
+		bestCost := time.Duration(math.MaxInt64)
+		for _, id := range ids {
+			c := cost(planned[id], call)
+			log.Debug("Checked elevator", "elevator", id, "floor", planned[id].Floor, "cost", c)
+			if c < bestCost {
+				best, bestCost = id, c
+			}
+		}
+		if best == "" {
+			continue
+		}
+		assignments[call] = best
+		planned[best].Queue[call.Floor][call.Button] = true
+	}
+	return assignments
+}
+
+// The keys of `calls` in floor order, up before down
+func sortedCalls[V any](calls map[elevio.ButtonEvent]V) []elevio.ButtonEvent {
+	sorted := make([]elevio.ButtonEvent, 0, len(calls))
+	for call := range calls {
+		sorted = append(sorted, call)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Floor != sorted[j].Floor {
+			return sorted[i].Floor < sorted[j].Floor
+		}
+		return sorted[i].Button < sorted[j].Button
+	})
+	return sorted
+}
@@ -1,33 +1,47 @@
 package peerMonitor
 
 import (
-	"fmt"
 	"mainProject/config"
 	"mainProject/communication"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/metrics"
 	"mainProject/network/peers"
 	"mainProject/singleElevator"
 )
 
+var log = logging.For("peers")
+
+var txEnable = make(chan bool, 1)
+
 func RunMonitorPeers(peerUpdateChan chan peers.PeerUpdate, lostPeerChan chan string, newPeerChan chan string, localStatusUpdateChan chan config.Elevator) {
 	go monitorPeers(peerUpdateChan, lostPeerChan, newPeerChan, localStatusUpdateChan)
 	
-	txEnable := make(chan bool, 1)
 	txEnable <- true
 
-	go peers.Transmitter(30001, config.LocalID, txEnable) 
+	go peers.Transmitter(config.Cfg.Network.PeerPort, config.LocalID, txEnable) 
+}
+
+// Stops the peer heartbeats, so the other elevators see this one leave
+func StopTransmitting() {
+	txEnable <- false
 }
 
 // Monitor Peers and Notify Master Election & Order Assignment
 func monitorPeers(peerUpdateChan chan peers.PeerUpdate, lostPeerChan chan string, newPeerChan chan string, localStatusUpdateChan chan config.Elevator) {
 	for update := range peerUpdateChan {
-		fmt.Printf("Received peer update: New=%v, Lost=%v\n", update.New, update.Lost)
+		log.Info("Peer update", "peers", update.Peers, "new", update.New, "lost", update.Lost)
 		communication.UpdateElevatorStates(update.New, update.Lost)
+		communication.SetPeers(update.Peers)
+		metrics.PeersLost.Add(float64(len(update.Lost)))
 
 		for _, lostPeer := range update.Lost {
+			journal.Record(journal.Event{Kind: journal.PeerLost, Peer: lostPeer})
 			lostPeerChan <- lostPeer
 			localStatusUpdateChan <- singleElevator.GetElevatorState()
 		}
 		for _, newPeer := range update.New {
+			journal.Record(journal.Event{Kind: journal.PeerNew, Peer: newPeer})
 			newPeerChan <- newPeer
 			localStatusUpdateChan <- singleElevator.GetElevatorState()
 		}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mainProject/elevio"
+	"mainProject/journal"
+	"os"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Replays order event journals
+// -----------------------------------------------------------------------------
+// Merges the journals of one or more elevators and prints the path of every hall
+// call, with the time of each step relative to the button press. Calls that were
+// never served are flagged, together with the peer losses and master changes
+// that happened while they were waiting.
+//
+//	go run ./replay journal_elevator_1.jsonl journal_elevator_2.jsonl
+//	go run ./replay -timeline journal_elevator_1.jsonl
+
+type order struct {
+	id     string
+	call   *elevio.ButtonEvent
+	events []journal.Event
+}
+
+func main() {
+	timeline := flag.Bool("timeline", false, "Print every event in order instead of grouping them by hall call")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-timeline] journal...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var readers []io.Reader
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	events, err := journal.Read(readers...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(events) == 0 {
+		fmt.Println("No events")
+		return
+	}
+
+	if *timeline {
+		printTimeline(events)
+	} else {
+		printOrders(events)
+	}
+}
+
+func printTimeline(events []journal.Event) {
+	start := events[0].Time
+	for _, e := range events {
+		fmt.Printf("%10s  %s\n", offset(start, e.Time), describe(e))
+	}
+}
+
+func printOrders(events []journal.Event) {
+	orders := groupByOrder(events)
+	var served, unserved int
+	var latencies []time.Duration
+
+	for _, o := range orders {
+		start := o.events[0].Time
+		end := o.events[len(o.events)-1].Time
+		fmt.Printf("Order %s: %s\n", o.id, callName(o.call))
+		for _, e := range eventsDuring(events, o, start, end) {
+			fmt.Printf("  %10s  %s\n", offset(start, e.Time), describe(e))
+		}
+
+		if servedEvent := find(o.events, journal.HallCallServed, ""); servedEvent != nil {
+			latency := servedEvent.Time.Sub(start)
+			fmt.Printf("  Served by %s after %s\n\n", servedEvent.Node, latency.Round(time.Millisecond))
+			served++
+			latencies = append(latencies, latency)
+			continue
+		}
+		unserved++
+		if lit := litAt(o.events); lit != nil {
+			fmt.Printf("  NOT SERVED: the light went on at %s, but no elevator opened its door\n", offset(start, lit.Time))
+		} else {
+			fmt.Printf("  NOT SERVED\n")
+		}
+		if assigned := findLast(o.events, journal.HallCallAssigned); assigned != nil {
+			fmt.Printf("  Last assigned to %s at %s\n", assigned.Peer, offset(start, assigned.Time))
+		}
+		for _, e := range o.events {
+			if e.Kind == journal.MessageFailed {
+				fmt.Printf("  Failed to deliver %s to %s at %s\n", e.Message, e.Peer, offset(start, e.Time))
+			}
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("%d hall calls: %d served, %d not served\n", served+unserved, served, unserved)
+	if len(latencies) > 0 {
+		min, max, sum := latencies[0], latencies[0], time.Duration(0)
+		for _, l := range latencies {
+			if l < min {
+				min = l
+			}
+			if l > max {
+				max = l
+			}
+			sum += l
+		}
+		avg := sum / time.Duration(len(latencies))
+		fmt.Printf("Service time: min %s, avg %s, max %s\n", min.Round(time.Millisecond), avg.Round(time.Millisecond), max.Round(time.Millisecond))
+	}
+}
+
+// Groups the events of each hall call by order ID, in the order the calls first appear
+func groupByOrder(events []journal.Event) []*order {
+	var orders []*order
+	byID := make(map[string]*order)
+	for _, e := range events {
+		if e.OrderID == "" {
+			continue
+		}
+		o, exists := byID[e.OrderID]
+		if !exists {
+			o = &order{id: e.OrderID}
+			byID[e.OrderID] = o
+			orders = append(orders, o)
+		}
+		if o.call == nil && e.Call != nil {
+			o.call = e.Call
+		}
+		o.events = append(o.events, e)
+	}
+	return orders
+}
+
+// The events of the order, together with the network events on any node while it was open
+func eventsDuring(events []journal.Event, o *order, start, end time.Time) []journal.Event {
+	var result []journal.Event
+	for _, e := range events {
+		if e.OrderID == o.id {
+			result = append(result, e)
+			continue
+		}
+		isNetworkEvent := e.Kind == journal.PeerLost || e.Kind == journal.PeerNew || e.Kind == journal.MasterChanged ||
+			e.Kind == journal.SplitBrainDetected || e.Kind == journal.WorldViewsMerged
+		if isNetworkEvent && !e.Time.Before(start) && !e.Time.After(end) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func find(events []journal.Event, kind journal.Kind, detail string) *journal.Event {
+	for i := range events {
+		if events[i].Kind == kind && (detail == "" || events[i].Detail == detail) {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+func findLast(events []journal.Event, kind journal.Kind) *journal.Event {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Kind == kind {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+// The first event that turned the hall light on
+func litAt(events []journal.Event) *journal.Event {
+	for i, e := range events {
+		if (e.Kind == journal.LightOrderSent || e.Kind == journal.LightOrderReceived) && e.Detail == "on" {
+			return &events[i] // Journals of older versions
+		}
+		if e.Kind == journal.HallOrderConfirmed {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+func describe(e journal.Event) string {
+	parts := []string{fmt.Sprintf("%-12s %s", e.Node, e.Kind)}
+	if e.Call != nil {
+		parts = append(parts, callName(e.Call))
+	}
+	if e.Peer != "" {
+		parts = append(parts, "peer="+e.Peer)
+	}
+	if e.Message != "" {
+		parts = append(parts, "message="+e.Message)
+	}
+	if e.SeqNum != 0 {
+		parts = append(parts, fmt.Sprintf("seq=%d", e.SeqNum))
+	}
+	if e.Detail != "" {
+		parts = append(parts, e.Detail)
+	}
+	if e.OrderID != "" {
+		parts = append(parts, "order="+e.OrderID)
+	}
+	return strings.Join(parts, "  ")
+}
+
+func callName(call *elevio.ButtonEvent) string {
+	if call == nil {
+		return "unknown call"
+	}
+	return fmt.Sprintf("floor %d %s", call.Floor, call.Button)
+}
+
+func offset(start, t time.Time) string {
+	return "+" + t.Sub(start).Round(time.Millisecond).String()
+}
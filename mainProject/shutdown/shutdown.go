@@ -0,0 +1,117 @@
+package shutdown
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/masterElection"
+	"mainProject/peerMonitor"
+	"mainProject/singleElevator"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Graceful shutdown
+// -----------------------------------------------------------------------------
+// On SIGTERM, SIGINT or a request from the HTTP API the elevator leaves the
+// network without making the others wait for the peer timeout:
+//
+//  1. It goes out of service and hands its hall calls back.
+//  2. It waits for the messages still waiting for an ack.
+//  3. A master hands mastership to the next elevator and sends it the backup
+//     statuses, and the hall calls no elevator could take are forwarded to it.
+//     In peer mode there is no master, and the peers take over the hall calls.
+//  4. It stops its peer heartbeats and exits.
+//
+// A second signal exits at once.
+
+var log = logging.For("shutdown")
+
+// Longest wait for each step, so a broken network cannot keep the elevator from exiting
+const stepTimeout = 5 * time.Second
+
+// Handed over statuses are retried without backoff, so a next master that is
+// lost too holds the shutdown up for about a second per status, not several
+var handoffPolicy = communication.RetryPolicy{Backoff: 1}
+
+var requests = make(chan string, 1)
+
+// Asks for a graceful shutdown, e.g. from the HTTP API. Returns at once.
+func Request(reason string) {
+	select {
+	case requests <- reason:
+	default: // Already requested
+	}
+}
+
+// Waits for a shutdown signal or request, then hands over and exits the process
+func Run() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	var reason string
+	select {
+	case sig := <-signals:
+		reason = sig.String()
+	case reason = <-requests:
+	}
+	log.Info("Shutting down", "reason", reason, "master", config.MasterID() == config.LocalID)
+
+	go func() {
+		<-signals
+		log.Warn("Second signal, exiting without handing over")
+		journal.Close()
+		os.Exit(1)
+	}()
+
+	handOver()
+	log.Info("Shut down")
+	journal.Close()
+	os.Exit(0)
+}
+
+func handOver() {
+	if err := singleElevator.Retire(stepTimeout); err != nil {
+		log.Warn("Could not take the elevator out of service, leaving anyway", "err", err)
+	}
+	waitForAcks()
+
+	if config.Cfg.AssignmentMode == config.PeerAssignment {
+		// No mastership to hand over
+	} else if config.MasterID() == config.LocalID {
+		newMaster, err := masterElection.HandOff(stepTimeout)
+		if err != nil {
+			log.Warn("Could not hand mastership over, the others will elect a master after the timeout", "err", err)
+		} else {
+			communication.SendHandoff(newMaster, handoffPolicy)
+		}
+	} else {
+		// Make sure this elevator never takes over while leaving
+		masterElection.HandOff(stepTimeout)
+	}
+	waitForAcks() // Hall calls forwarded to the new master
+
+	peerMonitor.StopTransmitting()
+}
+
+// Waits until no message has been waiting for an ack for a retry interval, or
+// stepTimeout has passed. The quiet period lets hall calls handed back just now be sent.
+func waitForAcks() {
+	const pollInterval = 50 * time.Millisecond
+	deadline := time.Now().Add(stepTimeout)
+	quietSince := time.Now()
+	for time.Since(quietSince) < config.Cfg.Retry.RetryInterval.Duration {
+		if time.Now().After(deadline) {
+			log.Warn("Exiting with messages not acknowledged", "seq", communication.GetPendingAcks())
+			return
+		}
+		time.Sleep(pollInterval)
+		if len(communication.GetPendingAcks()) > 0 {
+			quietSince = time.Now()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"mainProject/elevio"
+	"mainProject/elevio/sim"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pure-Go replacement for the external elevatorserver. Listens on SERVER_PORT
+// (or ELEVATOR_PORT) so the elevator binary can connect to it as usual, and
+// reads simple commands from stdin to press buttons and flip switches.
+func main() {
+	defaultPort := os.Getenv("SERVER_PORT")
+	if defaultPort == "" {
+		defaultPort = os.Getenv("ELEVATOR_PORT")
+	}
+	if defaultPort == "" {
+		defaultPort = "15657"
+	}
+	port := flag.String("port", defaultPort, "TCP port to serve the elevator protocol on")
+	numFloors := flag.Int("floors", 4, "Number of floors")
+	travelTime := flag.Duration("travel", sim.DefaultTravelTime, "Travel time between two adjacent floors")
+	interactive := flag.Bool("interactive", true, "Read commands from stdin")
+	flag.Parse()
+
+	simulator := sim.New(*numFloors, 0, *travelTime)
+	if *interactive {
+		go readCommands(simulator)
+	}
+	log.Fatal(simulator.ListenAndServe("localhost:" + *port))
+}
+
+func readCommands(simulator *sim.Simulator) {
+	printHelp()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "up", "down", "cab":
+			if len(fields) != 2 {
+				printHelp()
+				continue
+			}
+			floor, err := strconv.Atoi(fields[1])
+			if err != nil {
+				printHelp()
+				continue
+			}
+			button := map[string]elevio.ButtonType{"up": elevio.BT_HallUp, "down": elevio.BT_HallDown, "cab": elevio.BT_Cab}[fields[0]]
+			simulator.PressButton(button, floor)
+		case "obs":
+			simulator.SetObstruction(!simulator.State().Obstruction)
+		case "stop":
+			simulator.SetStop(!simulator.State().Stop)
+		case "state":
+			printState(simulator.State())
+		default:
+			printHelp()
+		}
+	}
+}
+
+func printHelp() {
+	fmt.Println("Commands: up <floor> | down <floor> | cab <floor> | obs (toggle) | stop (toggle) | state")
+}
+
+func printState(s sim.State) {
+	fmt.Printf("[%s] Position: %.2f | Floor: %d | Direction: %d | Door: %v | Obstruction: %v | Stop: %v\n",
+		time.Now().Format("15:04:05"), s.Position, s.Floor, s.Direction, s.DoorLamp, s.Obstruction, s.Stop)
+	for f := len(s.ButtonLamps) - 1; f >= 0; f-- {
+		fmt.Printf("  Floor %d | Up: %v | Down: %v | Cab: %v\n", f, s.ButtonLamps[f][elevio.BT_HallUp], s.ButtonLamps[f][elevio.BT_HallDown], s.ButtonLamps[f][elevio.BT_Cab])
+	}
+}
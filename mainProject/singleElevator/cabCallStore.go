@@ -0,0 +1,126 @@
+package singleElevator
+
+import (
+	"encoding/json"
+	"fmt"
+	"mainProject/config"
+	"mainProject/elevio"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// -----------------------------------------------------------------------------
+// Durable storage of cab calls
+// -----------------------------------------------------------------------------
+// Cab calls are written to a local file every time the cab queue changes, and
+// restored in InitElevator. Any backup the master sends afterwards is merged in
+// through the normal assignment path, so a cab call survives both a lone
+// elevator restarting and the master restarting at the same time.
+
+type cabCallJournal struct {
+	ID       string
+	CabCalls []int // Floors with an active cab call
+}
+
+var (
+	cabCallFile   string // Empty when persistence is disabled
+	savedCabCalls []int
+)
+
+func initCabCallStore() {
+	cabCallFile = ""
+	savedCabCalls = []int{}
+	if config.StateDir == "" {
+		return
+	}
+	cabCallFile = filepath.Join(config.StateDir, fmt.Sprintf("cabcalls_%s.json", config.LocalID))
+}
+
+func currentCabCalls() []int {
+	floors := []int{}
+	for floor := range elevator.Queue {
+		if elevator.Queue[floor][elevio.BT_Cab] {
+			floors = append(floors, floor)
+		}
+	}
+	return floors
+}
+
+// Reads the cab calls saved by a previous run into the queue and lights their lamps
+func restoreCabCalls() {
+	if cabCallFile == "" {
+		return
+	}
+	data, err := os.ReadFile(cabCallFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Error("Could not read saved cab calls", "file", cabCallFile, "err", err)
+		return
+	}
+	var journal cabCallJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		log.Warn("Ignoring corrupt cab call file", "file", cabCallFile, "err", err)
+		return
+	}
+	for _, floor := range journal.CabCalls {
+		if floor < 0 || floor >= config.NumFloors {
+			continue
+		}
+		elevator.Queue[floor][elevio.BT_Cab] = true
+		driver.SetButtonLamp(elevio.BT_Cab, floor, true)
+	}
+	savedCabCalls = currentCabCalls()
+	log.Info("Restored cab calls from disk", "floors", savedCabCalls)
+}
+
+// Saves the cab calls if they changed since the last save
+func persistCabCalls() {
+	if cabCallFile == "" {
+		return
+	}
+	floors := currentCabCalls()
+	if reflect.DeepEqual(floors, savedCabCalls) {
+		return
+	}
+	data, _ := json.Marshal(cabCallJournal{ID: config.LocalID, CabCalls: floors})
+	if err := writeFileAtomic(cabCallFile, data); err != nil {
+		log.Error("Could not save cab calls", "file", cabCallFile, "err", err)
+		return
+	}
+	savedCabCalls = floors
+}
+
+// Writes to a temporary file which is synced and then renamed over the target,
+// so a crash leaves either the old or the new contents, never a partial file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself is durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
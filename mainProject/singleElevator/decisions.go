@@ -32,7 +32,7 @@ func ChooseDirection(e config.Elevator) elevio.MotorDirection {
 }
 
 func HasOrdersAbove(e config.Elevator) bool {
-	for f := e.Floor + 1; f < config.NumFloors; f++ {
+	for f := e.Floor + 1; f < len(e.Queue); f++ {
 		for b := 0; b < config.NumButtons; b++ {
 			if e.Queue[f][b] {
 				return true
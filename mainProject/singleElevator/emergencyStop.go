@@ -0,0 +1,90 @@
+package singleElevator
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"mainProject/journal"
+	"mainProject/logging"
+)
+
+// Set when the elevator is released from an emergency stop between floors, and
+// has to stop at the next floor it reaches regardless of orders
+var returningToFloor bool
+
+// -----------------------------------------------------------------------------
+// Handles presses and releases of the stop button
+// -----------------------------------------------------------------------------
+// While the button is pressed the elevator is in EmergencyStop: the motor is halted,
+// the stop lamp is lit, the door is open if the car is at a floor, and the elevator
+// is reported as unavailable so its hall calls are handed to other elevators.
+// Cab calls are kept and served once the button is released.
+func ProcessStopButton(pressed bool, hallCallChan chan elevio.ButtonEvent, orderStatusChan chan communication.OrderStatusMessage, localStatusUpdateChan chan config.Elevator) {
+	if pressed {
+		enterEmergencyStop(hallCallChan, localStatusUpdateChan)
+	} else if elevator.State == config.EmergencyStop {
+		exitEmergencyStop(orderStatusChan)
+	}
+	publishState(localStatusUpdateChan)
+}
+
+func enterEmergencyStop(hallCallChan chan elevio.ButtonEvent, localStatusUpdateChan chan config.Elevator) {
+	log.Warn("Stop button pressed, entering emergency stop")
+	driver.SetMotorDirection(elevio.MD_Stop)
+	driver.SetStopLamp(true)
+
+	movementTimer.Stop()
+	obstructionTimer.Stop()
+	doorTimer.Stop()
+	clearOppositeDirectionTimer.Stop()
+	returningToFloor = false
+
+	if floor := driver.GetFloor(); floor != -1 {
+		elevator.Floor = floor
+		driver.SetDoorOpenLamp(true)
+	}
+	elevator.State = config.EmergencyStop
+	elevator.Direction = elevio.MD_Stop
+
+	// Make sure the unavailable state is out before the hall calls are handed back
+	publishState(localStatusUpdateChan)
+	handBackHallCalls(hallCallChan)
+}
+
+// Resumes normal operation. At a floor the door closes after the usual door time,
+// between floors the elevator moves down to the nearest floor and opens the door there.
+func exitEmergencyStop(orderStatusChan chan communication.OrderStatusMessage) {
+	log.Info("Stop button released, leaving emergency stop")
+	driver.SetStopLamp(false)
+
+	if floor := driver.GetFloor(); floor != -1 {
+		elevator.Floor = floor
+		elevator.State = config.DoorOpen
+		driver.SetDoorOpenLamp(true)
+		HandleStateTransition(orderStatusChan)
+		return
+	}
+	log.Info("Released between floors, returning to the floor below")
+	returningToFloor = true
+	elevator.State = config.Moving
+	elevator.Direction = elevio.MD_Down
+	movementTimer.Reset(config.NotMovingTimeLimit)
+	driver.SetMotorDirection(elevio.MD_Down)
+}
+
+// Removes all hall calls from the queue and passes them back to order assignment,
+// so they can be given to an available elevator. The hall lamps stay on, as the calls are still active.
+func handBackHallCalls(hallCallChan chan elevio.ButtonEvent) {
+	for floor := range elevator.Queue {
+		for button := elevio.BT_HallUp; button <= elevio.BT_HallDown; button++ {
+			if !elevator.Queue[floor][button] {
+				continue
+			}
+			elevator.Queue[floor][button] = false
+			hallCall := elevio.ButtonEvent{Floor: floor, Button: button}
+			log.Info("Handing back hall call", "floor", floor, "button", button, "order", logging.OrderID(hallCall))
+			journal.RecordCall(journal.HallCallHandedBack, hallCall, logging.OrderID(hallCall), "")
+			hallCallChan <- hallCall
+		}
+	}
+}
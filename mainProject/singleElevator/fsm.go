@@ -1,104 +1,135 @@
 package singleElevator
 
 import (
-	"fmt"
 	"mainProject/communication"
 	"mainProject/config"
 	"mainProject/elevio"
-	"os"
-	"time"
+	"sync"
 )
 
-var elevator config.Elevator
+var (
+	elevator config.Elevator // Only used by the FSM goroutine, other goroutines read publishedState
+	driver   elevio.Driver   // Hardware backend, injected through InitElevator
 
+	publishedState config.Elevator
+	publishedMutex sync.Mutex
+)
+
+// Returns the state last published by the FSM. Safe to call from any goroutine.
 func GetElevatorState() config.Elevator {
-	return elevator
+	publishedMutex.Lock()
+	defer publishedMutex.Unlock()
+	state := publishedState
+	state.Queue = publishedState.Queue.Clone()
+	return state
+}
+
+// A copy of the state, for the FSM goroutine
+func currentState() config.Elevator {
+	state := elevator
+	state.Queue = elevator.Queue.Clone()
+	return state
 }
 
-func InitElevator(localStatusUpdateChan chan config.Elevator) {
+// Publishes the state after a change. The local status in the shared statuses is
+// updated at once, so order assignment sees an unavailable elevator before any
+// hall call it hands back, and the communication goroutine broadcasts it.
+func publishState(localStatusUpdateChan chan config.Elevator) {
+	state := currentState()
+	publishedMutex.Lock()
+	publishedState = state
+	publishedMutex.Unlock()
+	communication.SetLocalStatus(state)
+	localStatusUpdateChan <- state
+}
+
+func InitElevator(elevatorDriver elevio.Driver, localStatusUpdateChan chan config.Elevator) {
+	driver = elevatorDriver
+	initTimers()
 
 	elevator = config.Elevator{
 		Floor:      0,
 		Direction:  elevio.MD_Stop,
 		State:      config.Idle,
 		Obstructed: false,
-		Queue:      [config.NumFloors][config.NumButtons]bool{}, 
+		Queue:      config.NewQueue(config.NumFloors),
 	}
-	//Clearing all button lights
+	//Clearing all button lights. Hall lamps start dark, as order assignment sets them only when their state changes
 	for f := 0; f < config.NumFloors; f++ {
 		for b := 0; b < config.NumButtons; b++ {
 			button := elevio.ButtonType(b)
-			elevio.SetButtonLamp(button, f, false)
+			if !config.ButtonExists(f, button) {
+				continue
+			}
+			driver.SetButtonLamp(button, f, false)
 		}
 	}
+	driver.SetStopLamp(false)
 
-	elevator.Obstructed = elevio.GetObstruction()
+	// Cab calls from before a restart are restored and lit again
+	initCabCallStore()
+	restoreCabCalls()
+
+	elevator.Obstructed = driver.GetObstruction()
 	//Correctly sets current floor. Moves elevator down to floor below if between floors
-	floor := elevio.GetFloor()
-	fmt.Printf("Read initial floor as %v\n", floor)
+	floor := driver.GetFloor()
+	log.Debug("Read initial floor", "floor", floor)
 	switch floor{
 	case -1:
-		for elevio.GetFloor() == -1{
-			elevio.SetMotorDirection(elevio.MD_Down)
+		for driver.GetFloor() == -1{
+			driver.SetMotorDirection(elevio.MD_Down)
 		}
-		elevio.SetMotorDirection(elevio.MD_Stop)
-		elevator.Floor = elevio.GetFloor()
+		driver.SetMotorDirection(elevio.MD_Stop)
+		elevator.Floor = driver.GetFloor()
 		
 	default:
-		elevator.Floor = elevio.GetFloor()
+		elevator.Floor = driver.GetFloor()
 	}
-	elevio.SetFloorIndicator(elevator.Floor)
-	localStatusUpdateChan <- GetElevatorState()
-	fmt.Printf("I'm starting at floor %v\n", elevator.Floor)
+	driver.SetFloorIndicator(elevator.Floor)
+	publishState(localStatusUpdateChan)
+	log.Info("Starting", "floor", elevator.Floor)
 
 	//Door is open on reinitialization to make sure the door does not close and continue as normal if an obstruction is present
 	elevator.State = config.DoorOpen
 	if elevator.Floor != -1 {
-		elevio.SetDoorOpenLamp(true)
-		time.Sleep(config.DoorOpenTime * time.Second)
-		elevio.SetDoorOpenLamp(false)
+		driver.SetDoorOpenLamp(true)
+		clk.Sleep(config.DoorOpenTime)
+		driver.SetDoorOpenLamp(false)
 	}
 }
 
 func HandleStateTransition(orderStatusChan chan communication.OrderStatusMessage) {
-	fmt.Printf("Handling state transition from %v\n", elevator.State)
+	log.Debug("Handling state transition", "state", elevator.State)
 	switch elevator.State {
 	case config.Idle:
 		obstructionTimer.Stop()
 		nextDir := ChooseDirection(elevator)
-		fmt.Printf("ChooseDirection() returned: %v\n", nextDir) 
+		log.Debug("Chose direction", "direction", nextDir)
 		if nextDir != elevio.MD_Stop {
-			fmt.Println("Transitioning from Idle to Moving...")
+			log.Debug("Transitioning from Idle to Moving")
 	
-			movementTimer.Reset(notMovingTimeLimit * time.Second)
+			movementTimer.Reset(config.NotMovingTimeLimit)
 			elevator.State = config.Moving
 			elevator.Direction = nextDir
 			clearLingeringHallCalls(nextDir, orderStatusChan) //Checks whether we should clear an "old" hall call that has not serviced any cab orders yet.
-			elevio.SetMotorDirection(nextDir)
+			driver.SetMotorDirection(nextDir)
 
 		} else {
-			fmt.Println("No pending orders, staying in Idle.")
+			log.Debug("No pending orders, staying in Idle")
 			movementTimer.Stop()
 		}
 	case config.Moving:
 		obstructionTimer.Stop()
-		fmt.Println("Elevator is moving...")
-		elevio.SetMotorDirection(elevator.Direction)
+		log.Debug("Elevator is moving")
+		driver.SetMotorDirection(elevator.Direction)
 	case config.DoorOpen:
 		movementTimer.Stop()
 		if elevator.Obstructed {
-			fmt.Println("Door remains open due to obstruction.")
+			log.Info("Door remains open due to obstruction")
 			doorTimer.Stop()
-			obstructionTimer.Reset(obstructionTimeLimit * time.Second)
+			obstructionTimer.Reset(config.ObstructionTimeLimit)
 			return
 		}
-		doorTimer.Reset(config.DoorOpenTime * time.Second)
-	fmt.Println()
+		doorTimer.Reset(config.DoorOpenTime)
 	}
 }
-
-// Forcing shutdown when elevator is in a fault state
-func forceShutdown(reason string) {
-	fmt.Printf("Forcefully shutting down the system due to: %s\n", reason)
-	os.Exit(1)
-}
\ No newline at end of file
@@ -0,0 +1,266 @@
+package singleElevator
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"testing"
+	"time"
+)
+
+func cab(floor int) elevio.ButtonEvent {
+	return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_Cab}
+}
+
+func hallUp(floor int) elevio.ButtonEvent {
+	return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallUp}
+}
+
+func hallDown(floor int) elevio.ButtonEvent {
+	return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallDown}
+}
+
+// A harness with the car idle at `floor`, after the door cycle of the start
+func idleAt(t *testing.T, floor int) *harness {
+	t.Helper()
+	h := newHarness(floor)
+	h.Advance(config.DoorOpenTime)
+	if state := h.Elevator().State; state != config.Idle {
+		t.Fatalf("state after start = %v, want Idle", state)
+	}
+	return h
+}
+
+func TestDoorTiming(t *testing.T) {
+	tests := []struct {
+		name     string
+		after    time.Duration
+		wantOpen bool
+	}{
+		{"just arrived", 0, true},
+		{"before door time", config.DoorOpenTime - time.Millisecond, true},
+		{"at door time", config.DoorOpenTime, false},
+		{"long after", 10 * config.DoorOpenTime, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := idleAt(t, 0)
+			h.PressButton(cab(2))
+			if dir := h.MotorDirection(); dir != elevio.MD_Up {
+				t.Fatalf("motor = %v after cab call above, want up", dir)
+			}
+			h.DepartFloor()
+			h.ArriveAtFloor(1)
+			if h.DoorOpen() || h.MotorDirection() != elevio.MD_Up {
+				t.Fatalf("stopped at floor 1 without an order there")
+			}
+			h.ArriveAtFloor(2)
+			if dir := h.MotorDirection(); dir != elevio.MD_Stop {
+				t.Fatalf("motor = %v at the ordered floor, want stop", dir)
+			}
+
+			h.Advance(tt.after)
+			if open := h.DoorOpen(); open != tt.wantOpen {
+				t.Errorf("door open = %v, want %v", open, tt.wantOpen)
+			}
+			if lit := h.ButtonLamp(elevio.BT_Cab, 2); lit != tt.wantOpen {
+				t.Errorf("cab lamp lit = %v, want it cleared as the door closes", lit)
+			}
+		})
+	}
+}
+
+func TestCabCallAtCurrentFloorOpensDoor(t *testing.T) {
+	h := idleAt(t, 1)
+	h.PressButton(cab(1))
+	if !h.DoorOpen() || h.Elevator().State != config.DoorOpen {
+		t.Fatalf("door not opened for a cab call at the current floor")
+	}
+	h.Advance(config.DoorOpenTime)
+	if h.DoorOpen() || h.Elevator().Queue[1][elevio.BT_Cab] {
+		t.Errorf("cab call at the current floor not served")
+	}
+}
+
+func TestObstructionKeepsDoorOpen(t *testing.T) {
+	tests := []struct {
+		name          string
+		obstructedFor time.Duration
+		wantState     config.ElevatorState
+	}{
+		{"short obstruction", config.ObstructionTimeLimit / 2, config.DoorOpen},
+		{"long obstruction", config.ObstructionTimeLimit + time.Second, config.OutOfService},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := idleAt(t, 0)
+			h.PressButton(cab(0))
+			h.SetObstruction(true)
+			h.Advance(tt.obstructedFor)
+			if state := h.Elevator().State; state != tt.wantState {
+				t.Fatalf("state while obstructed = %v, want %v", state, tt.wantState)
+			}
+			if !h.DoorOpen() {
+				t.Fatalf("door closed while obstructed")
+			}
+
+			h.SetObstruction(false)
+			if !h.DoorOpen() {
+				t.Errorf("door closed at once when the obstruction was cleared")
+			}
+			h.Advance(config.DoorOpenTime)
+			if h.DoorOpen() {
+				t.Errorf("door still open a door period after the obstruction was cleared")
+			}
+			if state := h.Elevator().State; state != config.Idle {
+				t.Errorf("state after the obstruction = %v, want Idle", state)
+			}
+		})
+	}
+}
+
+func TestOppositeDirectionClearedAfterExtraDoorPeriod(t *testing.T) {
+	h := idleAt(t, 0)
+	h.AssignHallCall(hallUp(1))
+	h.AssignHallCall(hallDown(1))
+	h.PressButton(cab(3))
+	h.DepartFloor()
+	h.ArriveAtFloor(1)
+	if !h.DoorOpen() {
+		t.Fatalf("door not opened at a floor with hall calls")
+	}
+
+	// The call against the direction of travel is cleared first, and the door
+	// stays open for another period before the call along it is cleared
+	h.Advance(config.DoorOpenTime)
+	queue := h.Elevator().Queue
+	if queue[1][elevio.BT_HallDown] || !queue[1][elevio.BT_HallUp] {
+		t.Fatalf("after one door period hall down = %v, hall up = %v, want only hall up left",
+			queue[1][elevio.BT_HallDown], queue[1][elevio.BT_HallUp])
+	}
+	if !h.DoorOpen() || h.MotorDirection() != elevio.MD_Stop {
+		t.Fatalf("door closed before the extra door period")
+	}
+
+	h.Advance(config.DoorOpenTime)
+	if h.Elevator().Queue[1][elevio.BT_HallUp] {
+		t.Errorf("hall up not cleared after the extra door period")
+	}
+	if h.DoorOpen() || h.MotorDirection() != elevio.MD_Up {
+		t.Errorf("door open = %v, motor = %v, want closed and moving up to the cab call", h.DoorOpen(), h.MotorDirection())
+	}
+
+	finished := 0
+	for _, status := range h.OrderStatuses() {
+		if status.Status == communication.Finished && status.ButtonEvent.Floor == 1 {
+			finished++
+		}
+	}
+	if finished != 2 {
+		t.Errorf("%d hall calls at floor 1 reported finished, want 2", finished)
+	}
+}
+
+func TestSingleHallCallClearedAlongDirection(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    elevio.ButtonEvent
+		cabCall elevio.ButtonEvent
+		wantDir elevio.MotorDirection
+	}{
+		{"up call, going up", hallUp(1), cab(3), elevio.MD_Up},
+		{"down call, going down", hallDown(2), cab(0), elevio.MD_Down},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := 0
+			if tt.wantDir == elevio.MD_Down {
+				start = 3
+			}
+			h := idleAt(t, start)
+			h.AssignHallCall(tt.call)
+			h.DepartFloor()
+			h.ArriveAtFloor(tt.call.Floor)
+			h.PressButton(tt.cabCall)
+
+			h.Advance(config.DoorOpenTime)
+			if h.Elevator().Queue[tt.call.Floor][tt.call.Button] {
+				t.Errorf("hall call not cleared after one door period")
+			}
+			if dir := h.MotorDirection(); dir != tt.wantDir {
+				t.Errorf("motor = %v, want %v", dir, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestEmergencyStopBetweenFloors(t *testing.T) {
+	h := idleAt(t, 0)
+	h.AssignHallCall(hallDown(2))
+	h.DepartFloor()
+
+	h.SetStopButton(true)
+	if h.MotorDirection() != elevio.MD_Stop || !h.StopLamp() || h.Elevator().State != config.EmergencyStop {
+		t.Fatalf("motor = %v, stop lamp = %v, state = %v, want stopped in EmergencyStop", h.MotorDirection(), h.StopLamp(), h.Elevator().State)
+	}
+	if calls := h.ForwardedHallCalls(); len(calls) != 1 || calls[0] != hallDown(2) {
+		t.Errorf("handed back %v, want the hall call", calls)
+	}
+
+	h.SetStopButton(false)
+	if h.MotorDirection() != elevio.MD_Down || h.StopLamp() {
+		t.Fatalf("motor = %v, stop lamp = %v after release, want moving down to a floor", h.MotorDirection(), h.StopLamp())
+	}
+	h.ArriveAtFloor(0)
+	if h.MotorDirection() != elevio.MD_Stop || !h.DoorOpen() {
+		t.Errorf("did not stop and open the door at the floor reached after release")
+	}
+}
+
+func TestMotorStallTakesElevatorOutOfService(t *testing.T) {
+	h := idleAt(t, 0)
+	h.PressButton(cab(3))
+	h.DepartFloor()
+	h.Advance(config.NotMovingTimeLimit)
+	if state := h.Elevator().State; state != config.OutOfService {
+		t.Fatalf("state = %v after the motor stalled, want OutOfService", state)
+	}
+	h.ArriveAtFloor(1)
+	if state := h.Elevator().State; state == config.OutOfService {
+		t.Errorf("still out of service after the car moved again")
+	}
+	if !h.Elevator().Queue[3][elevio.BT_Cab] {
+		t.Errorf("cab call lost while out of service")
+	}
+}
+
+func TestOutOfServiceDuringExtraDoorPeriod(t *testing.T) {
+	h := idleAt(t, 0)
+	h.AssignHallCall(hallUp(1))
+	h.AssignHallCall(hallDown(1))
+	h.PressButton(cab(3))
+	h.DepartFloor()
+	h.ArriveAtFloor(1)
+	h.Advance(config.DoorOpenTime) // Hall down is cleared, hall up waits for the extra door period
+
+	h.Retire()
+	h.Advance(2 * config.DoorOpenTime)
+	if state := h.Elevator().State; state != config.OutOfService {
+		t.Fatalf("state = %v after the extra door period, want OutOfService", state)
+	}
+	if dir := h.MotorDirection(); dir != elevio.MD_Stop {
+		t.Errorf("motor = %v while out of service, want stop", dir)
+	}
+	if calls := h.ForwardedHallCalls(); len(calls) != 1 || calls[0] != hallUp(1) {
+		t.Errorf("handed back %v, want the hall up call", calls)
+	}
+}
+
+func TestDoorCycleWithoutHallCallsReportsNothing(t *testing.T) {
+	h := idleAt(t, 0) // The door opens and closes at the start with no orders
+	h.PressButton(cab(0))
+	h.Advance(config.DoorOpenTime)
+	if statuses := h.OrderStatuses(); len(statuses) != 0 {
+		t.Errorf("sent order statuses %v for a floor without hall calls", statuses)
+	}
+}
@@ -0,0 +1,265 @@
+package singleElevator
+
+import (
+	"mainProject/clock"
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"sync"
+	"time"
+)
+
+// harness drives the single elevator FSM on a virtual clock, with a fake
+// driver instead of hardware and without any network. Events are handled
+// synchronously, exactly as the RunSingleElevator loop would handle them, so a
+// test can press buttons, move the car and advance time without waiting:
+//
+//	h := newHarness(0)
+//	h.Advance(config.DoorOpenTime) // The door cycle of the start
+//	h.PressButton(elevio.ButtonEvent{Floor: 2, Button: elevio.BT_Cab})
+//	h.ArriveAtFloor(1)
+//	h.ArriveAtFloor(2)
+//	// h.DoorOpen() is now true, and false again after another door period
+//
+// The FSM keeps its state in package variables, so only one harness may be
+// in use at a time.
+type harness struct {
+	Clock  *clock.Virtual
+	driver *fakeDriver
+
+	hallCallChan          chan elevio.ButtonEvent
+	orderStatusChan       chan communication.OrderStatusMessage
+	localStatusUpdateChan chan config.Elevator
+
+	forwardedHallCalls []elevio.ButtonEvent
+	orderStatuses      []communication.OrderStatusMessage
+}
+
+var harnessIdentity sync.Once
+
+// Creates a harness with the car resting at `startFloor` and its door open, as
+// after a start. The harness node acts as master, so order status messages are
+// handled locally instead of sent on the network.
+func newHarness(startFloor int) *harness {
+	h := &harness{
+		Clock:                 clock.NewVirtual(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+		driver:                newFakeDriver(startFloor),
+		hallCallChan:          make(chan elevio.ButtonEvent, 100),
+		orderStatusChan:       make(chan communication.OrderStatusMessage, 100),
+		localStatusUpdateChan: make(chan config.Elevator, 100),
+	}
+	// Set once, as goroutines of the communication package may still read them
+	harnessIdentity.Do(func() {
+		config.LocalID = "harness"
+		config.SetMaster(config.LocalID, 0)
+	})
+	clk = h.Clock
+
+	InitElevator(h.driver, h.localStatusUpdateChan)
+	HandleStateTransition(h.orderStatusChan) // Starts the door cycle, as RunSingleElevator does
+	h.settle()
+	return h
+}
+
+// -----------------------------------------------------------------------------
+// Events
+// -----------------------------------------------------------------------------
+func (h *harness) PressButton(event elevio.ButtonEvent) {
+	ProcessButtonPress(event, h.hallCallChan, h.orderStatusChan, h.localStatusUpdateChan)
+	h.settle()
+}
+
+// Assigns a hall call to this elevator, as order assignment would
+func (h *harness) AssignHallCall(order elevio.ButtonEvent) {
+	handleAssignedHallCall(order, h.hallCallChan, h.orderStatusChan, h.localStatusUpdateChan)
+	h.settle()
+}
+
+// Puts the car at `floor` and triggers the floor sensor
+func (h *harness) ArriveAtFloor(floor int) {
+	h.driver.setFloor(floor)
+	ProcessFloorArrival(floor, h.orderStatusChan, h.localStatusUpdateChan)
+	h.settle()
+}
+
+// Moves the car away from the floor sensor, between floors
+func (h *harness) DepartFloor() {
+	h.driver.setFloor(-1)
+}
+
+func (h *harness) SetObstruction(obstructed bool) {
+	h.driver.setObstruction(obstructed)
+	ProcessObstruction(obstructed, h.orderStatusChan)
+	h.settle()
+}
+
+func (h *harness) SetStopButton(pressed bool) {
+	h.driver.setStop(pressed)
+	ProcessStopButton(pressed, h.hallCallChan, h.orderStatusChan, h.localStatusUpdateChan)
+	h.settle()
+}
+
+// Takes the elevator out of service for a planned shutdown, as Retire does
+func (h *harness) Retire() {
+	driver.SetMotorDirection(elevio.MD_Stop)
+	enterOutOfService(shuttingDown, "planned shutdown", h.hallCallChan, h.localStatusUpdateChan)
+	h.settle()
+}
+
+// Advances virtual time by `d`, handling every timer that fires on the way in order
+func (h *harness) Advance(d time.Duration) {
+	target := h.Clock.Now().Add(d)
+	for h.Clock.AdvanceToNext(target) {
+		h.settle()
+	}
+	h.settle()
+}
+
+// Handles fired timers and internal messages until nothing is left to do
+func (h *harness) settle() {
+	for {
+		select {
+		case <-movementTimer.C():
+			onMovementTimeout(h.hallCallChan, h.localStatusUpdateChan)
+		case <-obstructionTimer.C():
+			onObstructionTimeout(h.hallCallChan, h.localStatusUpdateChan)
+		case <-doorTimer.C():
+			onDoorTimeout(h.orderStatusChan, h.localStatusUpdateChan)
+		case <-clearOppositeDirectionTimer.C():
+			onClearOppositeDirectionTimeout(h.orderStatusChan, h.localStatusUpdateChan)
+		case status := <-h.orderStatusChan:
+			h.orderStatuses = append(h.orderStatuses, status)
+			handleOrderStatus(status, nil)
+		case hallCall := <-h.hallCallChan:
+			h.forwardedHallCalls = append(h.forwardedHallCalls, hallCall)
+		case <-h.localStatusUpdateChan:
+		default:
+			return
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Observations
+// -----------------------------------------------------------------------------
+func (h *harness) Elevator() config.Elevator {
+	return currentState()
+}
+
+func (h *harness) MotorDirection() elevio.MotorDirection {
+	h.driver.mtx.Lock()
+	defer h.driver.mtx.Unlock()
+	return h.driver.motor
+}
+
+func (h *harness) DoorOpen() bool {
+	h.driver.mtx.Lock()
+	defer h.driver.mtx.Unlock()
+	return h.driver.doorLamp
+}
+
+func (h *harness) StopLamp() bool {
+	h.driver.mtx.Lock()
+	defer h.driver.mtx.Unlock()
+	return h.driver.stopLamp
+}
+
+func (h *harness) ButtonLamp(button elevio.ButtonType, floor int) bool {
+	h.driver.mtx.Lock()
+	defer h.driver.mtx.Unlock()
+	return h.driver.buttonLamps[floor][button]
+}
+
+// Hall calls the elevator passed on to order assignment
+func (h *harness) ForwardedHallCalls() []elevio.ButtonEvent {
+	return h.forwardedHallCalls
+}
+
+// Order status messages the elevator has sent
+func (h *harness) OrderStatuses() []communication.OrderStatusMessage {
+	return h.orderStatuses
+}
+
+// -----------------------------------------------------------------------------
+// Fake driver
+// -----------------------------------------------------------------------------
+type fakeDriver struct {
+	mtx         sync.Mutex
+	floor       int
+	motor       elevio.MotorDirection
+	buttonLamps [][3]bool
+	doorLamp    bool
+	stopLamp    bool
+	obstruction bool
+	stop        bool
+}
+
+func newFakeDriver(floor int) *fakeDriver {
+	return &fakeDriver{floor: floor, buttonLamps: make([][3]bool, config.NumFloors)}
+}
+
+func (d *fakeDriver) setFloor(floor int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.floor = floor
+}
+
+func (d *fakeDriver) setObstruction(obstructed bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.obstruction = obstructed
+}
+
+func (d *fakeDriver) setStop(pressed bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stop = pressed
+}
+
+func (d *fakeDriver) SetMotorDirection(dir elevio.MotorDirection) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.motor = dir
+}
+
+func (d *fakeDriver) SetButtonLamp(button elevio.ButtonType, floor int, value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.buttonLamps[floor][button] = value
+}
+
+func (d *fakeDriver) SetFloorIndicator(floor int) {}
+
+func (d *fakeDriver) SetDoorOpenLamp(value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.doorLamp = value
+}
+
+func (d *fakeDriver) SetStopLamp(value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stopLamp = value
+}
+
+func (d *fakeDriver) GetButton(button elevio.ButtonType, floor int) bool {
+	return false
+}
+
+func (d *fakeDriver) GetFloor() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.floor
+}
+
+func (d *fakeDriver) GetStop() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.stop
+}
+
+func (d *fakeDriver) GetObstruction() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.obstruction
+}
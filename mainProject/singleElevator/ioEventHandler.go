@@ -4,71 +4,98 @@ import (
 	"mainProject/config"
 	"mainProject/elevio"
 	"mainProject/communication"
-	"fmt"
+	"mainProject/journal"
+	"mainProject/logging"
+	"mainProject/metrics"
 	"time"
 )
 
 func ProcessButtonPress(event elevio.ButtonEvent, hallCallChan chan elevio.ButtonEvent, orderStatusChan chan communication.OrderStatusMessage, localStatusUpdateChan chan config.Elevator) {
-	fmt.Printf("Button pressed: %+v\n\n", event)
+	if !config.ButtonExists(event.Floor, event.Button) {
+		return
+	}
+	if event.Button != elevio.BT_Cab {
+		logging.NewOrderID(event, config.LocalID)
+	}
+	log.Info("Button pressed", "floor", event.Floor, "button", event.Button, "order", logging.OrderID(event))
+	journal.RecordCall(journal.ButtonPressed, event, logging.OrderID(event), "")
 	
 	// Cab calls are handled locally
 	if event.Button == elevio.BT_Cab{
 		elevator.Queue[event.Floor][event.Button] = true
-		elevio.SetButtonLamp(event.Button, event.Floor, true)
-		localStatusUpdateChan <- GetElevatorState()
+		driver.SetButtonLamp(event.Button, event.Floor, true)
+		persistCabCalls()
+		publishState(localStatusUpdateChan)
+
+		// Cab calls are kept while the elevator is unavailable, and served when it returns to service
+		if !elevator.State.IsAvailable() {
+			return
+		}
 		
 		// If the elevator is already at the requested floor, process it immediately
-		floorSensorValue := elevio.GetFloor()
+		floorSensorValue := driver.GetFloor()
 		if (elevator.Floor == event.Floor && floorSensorValue != -1 && elevator.State != config.Moving){
-			fmt.Println("Cab call at current floor, processing immediately...")
-			time.Sleep(3 * time.Second)
+			log.Info("Cab call at current floor, processing immediately")
+			clk.Sleep(config.DoorOpenTime)
 			ProcessFloorArrival(elevator.Floor, orderStatusChan, localStatusUpdateChan)
 			
-			localStatusUpdateChan <- GetElevatorState()
+			publishState(localStatusUpdateChan)
 		}else{
 			HandleStateTransition(orderStatusChan) 
 		}
 	} else {
+		metrics.HallCallsReceived.Inc()
+		metrics.HallCallPressed(event, time.Now())
 		hallCallChan <- event
 	}
 }
 
 func ProcessFloorArrival(floor int, orderStatusChan chan communication.OrderStatusMessage, localStatusUpdateChan chan config.Elevator) {
-	fmt.Printf("Floor sensor triggered: %+v\n", floor)
-	elevio.SetFloorIndicator(floor)
-	movementTimer.Reset(notMovingTimeLimit * time.Second)
+	log.Debug("Floor sensor triggered", "floor", floor)
+	driver.SetFloorIndicator(floor)
+	movementTimer.Reset(config.NotMovingTimeLimit)
+	recoverFromMotorStall()
 
-	if !hasOrdersAtFloor(floor) {
+	if !hasOrdersAtFloor(floor) && !returningToFloor {
 		return
 	}
+	returningToFloor = false
 	// Stop immediately if orders at current floor
-	elevio.SetMotorDirection(elevio.MD_Stop)
+	driver.SetMotorDirection(elevio.MD_Stop)
 	elevator.Floor = floor
-	fmt.Printf("Elevator position updated: Now at Floor %d\n\n", elevator.Floor)
-
-	fmt.Println("Transitioning from Moving to DoorOpen...")
+	log.Info("Stopped at floor, opening door", "floor", elevator.Floor)
 	elevator.State = config.DoorOpen
-	elevio.SetDoorOpenLamp(true)
-	doorTimer.Reset(config.DoorOpenTime * time.Second)
+	driver.SetDoorOpenLamp(true)
+	doorTimer.Reset(config.DoorOpenTime)
+	for button := elevio.BT_HallUp; button <= elevio.BT_HallDown; button++ {
+		if elevator.Queue[floor][button] {
+			hallCall := elevio.ButtonEvent{Floor: floor, Button: button}
+			metrics.HallCallServed(hallCall, time.Now())
+			journal.RecordCall(journal.HallCallServed, hallCall, logging.OrderID(hallCall), "")
+		}
+	}
 }
 
 func ProcessObstruction(obstructed bool, orderStatusChan chan communication.OrderStatusMessage) {
 	elevator.Obstructed = obstructed
 
+	if recoverFromLongObstruction(obstructed, orderStatusChan) {
+		return
+	}
 	if elevator.State != config.DoorOpen {
 		return
 	}
 	if obstructed{
 		movementTimer.Stop()
-		fmt.Printf("Obstruction detected: %+v\n", obstructed)
-		elevio.SetMotorDirection(elevio.MD_Stop)
-		elevio.SetDoorOpenLamp(true)
+		log.Info("Obstruction detected")
+		driver.SetMotorDirection(elevio.MD_Stop)
+		driver.SetDoorOpenLamp(true)
 		elevator.State = config.DoorOpen
 		HandleStateTransition(orderStatusChan)
 	} else {
-		fmt.Println("Obstruction cleared, transitioning to Idle...")
+		log.Info("Obstruction cleared")
 		obstructionTimer.Stop()
-		doorTimer.Reset(config.DoorOpenTime * time.Second)
+		doorTimer.Reset(config.DoorOpenTime)
 	}
 }
 
@@ -82,9 +109,10 @@ func hallCallClearOrder(floor int)(elevio.ButtonType, elevio.ButtonType, bool){
 
 	// Clear Cab Call if it exists
 	if hasCabCall {
-		elevio.SetButtonLamp(elevio.BT_Cab, floor, false)
+		driver.SetButtonLamp(elevio.BT_Cab, floor, false)
 		elevator.Queue[floor][elevio.BT_Cab] = false
-		fmt.Printf("Cleared cab call: Floor %d\n", floor)
+		persistCabCalls()
+		log.Info("Cleared cab call", "floor", floor)
 		if !hasUpCall && !hasDownCall{
 			return elevio.BT_Cab, elevio.BT_Cab, false
 		}
@@ -155,16 +183,18 @@ func clearAllOrdersAtFloor(floor int, orderStatusChan chan communication.OrderSt
 	if(firstClearButton == elevio.BT_Cab){
 		return
 	}
-	// Clear the first button immediately (announce direction)
-	elevio.SetButtonLamp(firstClearButton, floor, false)
+	// hallCallClearOrder defaults to hall-up when there is no hall call, which must not be reported as finished
+	if !elevator.Queue[floor][firstClearButton] {
+		return
+	}
+	// Clear the first button immediately (announce direction). Its lamp follows the hall order state
 	elevator.Queue[floor][firstClearButton] = false
-	fmt.Printf("Cleared hall call: Floor %d, Button %v\n", floor, firstClearButton)
+	log.Info("Cleared hall call", "floor", floor, "button", firstClearButton, "order", logging.OrderID(elevio.ButtonEvent{Floor: floor, Button: firstClearButton}))
 
 	//Send finished order status message to sync hall button lights
 	msg := communication.OrderStatusMessage{ButtonEvent: elevio.ButtonEvent{Floor: floor, Button: firstClearButton}, SenderID: config.LocalID, Status: communication.Finished}
 	communication.SendOrderStatus(msg, orderStatusChan)
-	MarkAssignmentAsCompleted(msg.SeqNum)
 
-	localStatusUpdateChan <- GetElevatorState()
+	publishState(localStatusUpdateChan)
 
 }
\ No newline at end of file
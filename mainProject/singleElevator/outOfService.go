@@ -0,0 +1,80 @@
+package singleElevator
+
+import (
+	"mainProject/communication"
+	"mainProject/config"
+	"mainProject/elevio"
+	"mainProject/metrics"
+)
+
+type outOfServiceCause int
+
+const (
+	motorStall outOfServiceCause = iota
+	longObstruction
+	shuttingDown // Planned shutdown, the elevator does not return to service
+)
+
+var currentOutOfServiceCause outOfServiceCause
+
+func (c outOfServiceCause) String() string {
+	switch c {
+	case motorStall:
+		return "motor_stall"
+	case longObstruction:
+		return "long_obstruction"
+	case shuttingDown:
+		return "shutdown"
+	}
+	return "unknown"
+}
+
+// -----------------------------------------------------------------------------
+// Handles faults that stop the elevator from serving orders
+// -----------------------------------------------------------------------------
+// The elevator stays alive in the OutOfService state: it is reported as unavailable,
+// hands its hall calls back for reassignment and keeps its cab calls. Lamps and
+// state survive, and the elevator returns to service by itself when the fault clears.
+func enterOutOfService(cause outOfServiceCause, reason string, hallCallChan chan elevio.ButtonEvent, localStatusUpdateChan chan config.Elevator) {
+	if cause == shuttingDown {
+		log.Info("Elevator out of service", "reason", reason, "cause", cause)
+	} else {
+		log.Error("Elevator out of service", "reason", reason, "cause", cause)
+	}
+	movementTimer.Stop()
+	obstructionTimer.Stop()
+	doorTimer.Stop()
+	clearOppositeDirectionTimer.Stop()
+
+	currentOutOfServiceCause = cause
+	metrics.OutOfServiceEvents.Inc(cause.String())
+	elevator.State = config.OutOfService
+
+	// Make sure the unavailable state is out before the hall calls are handed back
+	publishState(localStatusUpdateChan)
+	handBackHallCalls(hallCallChan)
+}
+
+// Called when the floor sensor triggers. A stalled motor that moves the car again
+// has recovered, so the elevator stops at the floor it reached and resumes normal operation.
+func recoverFromMotorStall() {
+	if elevator.State != config.OutOfService || currentOutOfServiceCause != motorStall {
+		return
+	}
+	log.Info("Movement detected, returning to service")
+	elevator.State = config.Moving
+	returningToFloor = true
+}
+
+// Called when the obstruction switch changes. The door closes as normal once it is cleared.
+func recoverFromLongObstruction(obstructed bool, orderStatusChan chan communication.OrderStatusMessage) bool {
+	if elevator.State != config.OutOfService || currentOutOfServiceCause != longObstruction {
+		return false
+	}
+	if !obstructed {
+		log.Info("Obstruction cleared, returning to service")
+		elevator.State = config.DoorOpen
+		doorTimer.Reset(config.DoorOpenTime)
+	}
+	return true
+}
@@ -1,63 +1,118 @@
 package singleElevator
 
 import (
+	"mainProject/clock"
 	"mainProject/elevio"
 	"mainProject/communication"
 	"mainProject/network/bcast"
 	"mainProject/config"
+	"mainProject/logging"
+	"errors"
 	"fmt"
 	"time"
 )
 
-const (
-	notMovingTimeLimit = 8 // Seconds
-	obstructionTimeLimit = 4 // Seconds
-)
+var log = logging.For("singleElevator")
 
 var (
-	movementTimer				= time.NewTimer(notMovingTimeLimit * time.Second)
-	obstructionTimer 			= time.NewTimer(obstructionTimeLimit * time.Second)
-	doorTimer 					= time.NewTimer(config.DoorOpenTime * time.Second)
-	clearOppositeDirectionTimer = time.NewTimer(config.DoorOpenTime * time.Second)
+	clk                         clock.Clock = clock.Real // Replaced by a virtual clock in the test harness
+	movementTimer               clock.Timer
+	obstructionTimer            clock.Timer
+	doorTimer                   clock.Timer
+	clearOppositeDirectionTimer clock.Timer
 	delayedButtonEvent 			  elevio.ButtonEvent // Store delayed call for later clearance
+	injectedButtonPress         = make(chan elevio.ButtonEvent, 20) // Button presses from outside the hardware, e.g. the HTTP API
+	revokedHallCalls            = make(chan elevio.ButtonEvent, 20) // Hall calls given to another elevator
+	retireRequests              = make(chan chan struct{})
 )
 
-func RunSingleElevator(hallCallChan chan elevio.ButtonEvent, assignedHallCallChan chan elevio.ButtonEvent, orderStatusChan chan communication.OrderStatusMessage, txAckChan chan communication.AckMessage, localStatusUpdateChan chan config.Elevator) {
-	
-	//Initial stop of timers, as we do not need them yet
+// Queues a button press that did not come from the hardware. It is handled by
+// ProcessButtonPress exactly like a physical press.
+func InjectButtonPress(event elevio.ButtonEvent) error {
+	if !config.ButtonExists(event.Floor, event.Button) {
+		return fmt.Errorf("there is no button %d at floor %d", event.Button, event.Floor)
+	}
+	select {
+	case injectedButtonPress <- event:
+		return nil
+	default:
+		return errors.New("too many button presses waiting to be handled")
+	}
+}
+
+// Queues the removal of a hall call from the local queue. Used by the master
+// when it gives a hall call held by several elevators to another one, and in
+// peer mode when a call moves to another elevator or is served elsewhere.
+func RevokeHallCall(call elevio.ButtonEvent) {
+	revokedHallCalls <- call
+}
+
+// Takes the elevator out of service for a planned shutdown: the motor stops, the
+// elevator is reported as unavailable and its hall calls are handed back.
+// Returns once the hall calls are handed back, or with an error after `timeout`
+// if the elevator does not get there. Cab calls stay saved for the next start.
+func Retire(timeout time.Duration) error {
+	done := make(chan struct{})
+	deadline := time.After(timeout)
+	select {
+	case retireRequests <- done:
+	case <-deadline:
+		return errors.New("the elevator is not handling requests")
+	}
+	select {
+	case <-done:
+		return nil
+	case <-deadline:
+		return fmt.Errorf("hall calls not handed back within %s", timeout)
+	}
+}
+
+// Sets a hall lamp of the local elevator, to follow the hall order states. The
+// only writer of hall lamps, so the lamps never disagree with the states.
+func SetHallLamp(call elevio.ButtonEvent, on bool) {
+	driver.SetButtonLamp(call.Button, call.Floor, on)
+}
+
+// Creates the FSM timers on the current clock. They start stopped, as we do not need them yet
+func initTimers() {
+	movementTimer               = clk.NewTimer(config.NotMovingTimeLimit)
+	obstructionTimer            = clk.NewTimer(config.ObstructionTimeLimit)
+	doorTimer                   = clk.NewTimer(config.DoorOpenTime)
+	clearOppositeDirectionTimer = clk.NewTimer(config.DoorOpenTime)
+
 	movementTimer.Stop()
 	obstructionTimer.Stop()
 	doorTimer.Stop()
 	clearOppositeDirectionTimer.Stop()
+}
+
+func RunSingleElevator(hallCallChan chan elevio.ButtonEvent, assignedHallCallChan chan elevio.ButtonEvent, orderStatusChan chan communication.OrderStatusMessage, txAckChan chan communication.AckMessage, localStatusUpdateChan chan config.Elevator) {
 
 	// Initialize elevator hardware event channels
 	buttonPress       := make(chan elevio.ButtonEvent)
 	floorSensor       := make(chan int)
 	obstructionSwitch := make(chan bool)
+	stopButton        := make(chan bool)
 
 
 	// Start polling hardware for events
-	go elevio.PollButtons(buttonPress)
-	go elevio.PollFloorSensor(floorSensor)
-	go elevio.PollObstructionSwitch(obstructionSwitch)
+	go elevio.PollButtons(driver, config.NumFloors, buttonPress)
+	go elevio.PollFloorSensor(driver, floorSensor)
+	go elevio.PollObstructionSwitch(driver, obstructionSwitch)
+	go elevio.PollStopButton(driver, stopButton)
 	
 
-	fmt.Printf("Single Elevator Module Running...\n\n")
+	log.Info("Single elevator module running")
 
-	//Start receivers for hall assignments, hall calls and light orders
+	//Start receivers for hall assignments and hall calls
 	assignedNetworkHallCallChan := make(chan communication.AssignmentMessage, 50) 
-	go bcast.Receiver(30002, assignedNetworkHallCallChan) // hallCallPort
+	go bcast.Receiver(config.Cfg.Network.AssignmentPort, assignedNetworkHallCallChan)
 
 	rawHallCallChan := make(chan communication.RawHallCallMessage, 50)
-	go bcast.Receiver(30003, rawHallCallChan) // rawHallCallPort
-
-	lightOrderChan := make(chan communication.LightOrderMessage, 50)
-	go bcast.Receiver(30006, lightOrderChan) // lightPort
+	go bcast.Receiver(config.Cfg.Network.RawHallCallPort, rawHallCallChan)
 
 	//Start Transmitter for acks
-	go bcast.Transmitter(30004, txAckChan) // ackPort
-
-	go flushRecentMessages()
+	go bcast.Transmitter(config.Cfg.Network.AckPort, txAckChan)
 
 	// Periodic Broadcast - Continuously broadcasts the elevator status to other elevators
     go func() {
@@ -67,6 +122,9 @@ func RunSingleElevator(hallCallChan chan elevio.ButtonEvent, assignedHallCallCha
 		}
     }()
 
+	// Start the door cycle, so cab calls restored from disk are served
+	HandleStateTransition(orderStatusChan)
+
 	for {
 		// I/O events
 		select {
@@ -76,67 +134,98 @@ func RunSingleElevator(hallCallChan chan elevio.ButtonEvent, assignedHallCallCha
 		case buttonEvent := <-buttonPress:
 			ProcessButtonPress(buttonEvent, hallCallChan, orderStatusChan, localStatusUpdateChan) 
 
+		case buttonEvent := <-injectedButtonPress:
+			ProcessButtonPress(buttonEvent, hallCallChan, orderStatusChan, localStatusUpdateChan)
+
+		case revokedCall := <-revokedHallCalls:
+			revokeHallCall(revokedCall, localStatusUpdateChan)
+
+		case done := <-retireRequests:
+			driver.SetMotorDirection(elevio.MD_Stop)
+			enterOutOfService(shuttingDown, "planned shutdown", hallCallChan, localStatusUpdateChan)
+			close(done)
+
 		case obstructionEvent := <-obstructionSwitch:
 			ProcessObstruction(obstructionEvent, orderStatusChan) 
+
+		case stopEvent := <-stopButton:
+			ProcessStopButton(stopEvent, hallCallChan, orderStatusChan, localStatusUpdateChan)
 		
 		// Hall calls
 		case assignedOrder := <-assignedHallCallChan:
-			handleAssignedHallCall(assignedOrder, orderStatusChan, localStatusUpdateChan) 
+			handleAssignedHallCall(assignedOrder, hallCallChan, orderStatusChan, localStatusUpdateChan) 
 		
 		case rawCall := <-rawHallCallChan:
 			handleAssignedRawHallCall(rawCall, hallCallChan, txAckChan) 
 		
 		case networkAssignedOrder := <-assignedNetworkHallCallChan:
-			handleAssignedNetworkHallCall(networkAssignedOrder, orderStatusChan, txAckChan, localStatusUpdateChan) 
-		
-		// Light orders
-		case lightOrder := <-lightOrderChan:
-			handleLightOrder(lightOrder, txAckChan)
+			handleAssignedNetworkHallCall(networkAssignedOrder, hallCallChan, orderStatusChan, txAckChan, localStatusUpdateChan) 
 		
 		// Order status
 		case status := <- orderStatusChan:
 			handleOrderStatus(status, txAckChan)
 
 		// Timers
-		case <- movementTimer.C:
-			forceShutdown("Power Loss")
+		case <- movementTimer.C():
+			onMovementTimeout(hallCallChan, localStatusUpdateChan)
 
-		case <- obstructionTimer.C:
-			forceShutdown("Obstructed too Long")
-		
-		case <- doorTimer.C:
-			if !elevator.Obstructed {
-				fmt.Println("Transitioning from DoorOpen to Idle...")
-				elevio.SetDoorOpenLamp(false)
-				firstClearButton, secondClearButton, shouldDelaySecondClear := hallCallClearOrder(elevator.Floor)
-				clearAllOrdersAtFloor(elevator.Floor, orderStatusChan, localStatusUpdateChan, firstClearButton)
-				if shouldDelaySecondClear{
-					fmt.Println("Keeping door open for an extra 3 seconds before changing direction...")
-					movementTimer.Stop()
-					clearOppositeDirectionTimer.Reset(config.DoorOpenTime * time.Second)
-					elevio.SetDoorOpenLamp(true)
-					delayedButtonEvent = elevio.ButtonEvent{Button: secondClearButton, Floor: elevator.Floor}
-				}else{
-				elevator.State = config.Idle
-				HandleStateTransition(orderStatusChan)
-				}
-			}else{
-				HandleStateTransition(orderStatusChan)
-			}
-
-		case <- clearOppositeDirectionTimer.C:
-			fmt.Printf("Clearing delayed opposite direction call: Floor %d, Button %v\n", delayedButtonEvent.Floor, delayedButtonEvent.Button)
-			elevio.SetDoorOpenLamp(false)
-			elevio.SetButtonLamp(delayedButtonEvent.Button, delayedButtonEvent.Floor, false)
-			elevator.Queue[delayedButtonEvent.Floor][delayedButtonEvent.Button] = false
-
-			//Send finished order status message to sync hall button lights
-			msg := communication.OrderStatusMessage{ButtonEvent: delayedButtonEvent, SenderID: config.LocalID, Status: communication.Finished}
-			communication.SendOrderStatus(msg, orderStatusChan)
-			MarkAssignmentAsCompleted(msg.SeqNum)
-			elevator.State = config.Idle
-			HandleStateTransition(orderStatusChan)
+		case <- obstructionTimer.C():
+			onObstructionTimeout(hallCallChan, localStatusUpdateChan)
+
+		case <- doorTimer.C():
+			onDoorTimeout(orderStatusChan, localStatusUpdateChan)
+
+		case <- clearOppositeDirectionTimer.C():
+			onClearOppositeDirectionTimeout(orderStatusChan, localStatusUpdateChan)
+		}
+		publishState(localStatusUpdateChan)	
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Timer events
+// -----------------------------------------------------------------------------
+func onMovementTimeout(hallCallChan chan elevio.ButtonEvent, localStatusUpdateChan chan config.Elevator) {
+	enterOutOfService(motorStall, "Power Loss", hallCallChan, localStatusUpdateChan)
+}
+
+func onObstructionTimeout(hallCallChan chan elevio.ButtonEvent, localStatusUpdateChan chan config.Elevator) {
+	enterOutOfService(longObstruction, "Obstructed too Long", hallCallChan, localStatusUpdateChan)
+}
+
+func onDoorTimeout(orderStatusChan chan communication.OrderStatusMessage, localStatusUpdateChan chan config.Elevator) {
+	if !elevator.Obstructed {
+		log.Debug("Transitioning from DoorOpen to Idle")
+		driver.SetDoorOpenLamp(false)
+		firstClearButton, secondClearButton, shouldDelaySecondClear := hallCallClearOrder(elevator.Floor)
+		clearAllOrdersAtFloor(elevator.Floor, orderStatusChan, localStatusUpdateChan, firstClearButton)
+		if shouldDelaySecondClear{
+			log.Info("Keeping door open before changing direction", "extra", config.DoorOpenTime)
+			movementTimer.Stop()
+			clearOppositeDirectionTimer.Reset(config.DoorOpenTime)
+			driver.SetDoorOpenLamp(true)
+			delayedButtonEvent = elevio.ButtonEvent{Button: secondClearButton, Floor: elevator.Floor}
+		}else{
+		elevator.State = config.Idle
+		HandleStateTransition(orderStatusChan)
 		}
-		localStatusUpdateChan <- GetElevatorState()	
+	}else{
+		HandleStateTransition(orderStatusChan)
+	}
+}
+
+func onClearOppositeDirectionTimeout(orderStatusChan chan communication.OrderStatusMessage, localStatusUpdateChan chan config.Elevator) {
+	// The timer is stopped when the elevator leaves service, but may have fired already
+	if elevator.State == config.OutOfService || elevator.State == config.EmergencyStop {
+		return
 	}
+	log.Info("Clearing delayed opposite direction call", "floor", delayedButtonEvent.Floor, "button", delayedButtonEvent.Button, "order", logging.OrderID(delayedButtonEvent))
+	driver.SetDoorOpenLamp(false)
+	elevator.Queue[delayedButtonEvent.Floor][delayedButtonEvent.Button] = false
+
+	//Send finished order status message to sync hall button lights
+	msg := communication.OrderStatusMessage{ButtonEvent: delayedButtonEvent, SenderID: config.LocalID, Status: communication.Finished}
+	communication.SendOrderStatus(msg, orderStatusChan)
+	elevator.State = config.Idle
+	HandleStateTransition(orderStatusChan)
 }
@@ -3,21 +3,33 @@ package main
 import (
 	"fmt"
 	"log"
+	"mainProject/config"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"time"
 )
 
 var (
-    elevatorID   = os.Getenv("ELEVATOR_ID")   
-    elevatorPort = os.Getenv("ELEVATOR_PORT") 
+    elevatorID   string
+    elevatorPort string
+    elevatorVars map[string]string // Extra environment the elevator is restarted with, so it gets the same configuration
 )
 
 func main() {
-	if elevatorID == "" || elevatorPort == "" {
-		log.Fatal("ELEVATOR_ID or ELEVATOR_PORT is not set! Exiting...")
+	// The supervisor takes the same configuration file, environment and flags as the elevator
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
 	}
+	if cfg.ID == "" {
+		log.Fatal("The elevator ID is not set (ELEVATOR_ID, -id or ID in the configuration file)! Exiting...")
+	}
+	elevatorID = cfg.ID
+	elevatorPort = strconv.Itoa(cfg.ElevatorPort)
+	elevatorVars = restartVariables(cfg)
 
 	log.Printf("Supervisor started for Elevator %s on port %s.", elevatorID, elevatorPort)
 
@@ -67,11 +79,17 @@ func restartElevator(elevatorID string) {
 	log.Printf("Restarting Elevator: %s on port %s...", elevatorID, elevatorPort)
 	var cmd *exec.Cmd
 
+	powershellVars, bashVars := "", ""
+	for name, value := range elevatorVars {
+		powershellVars += fmt.Sprintf(`$env:%s=\"%s\"; `, name, value)
+		bashVars += fmt.Sprintf(`%s="%s" `, name, value)
+	}
+
     if runtime.GOOS == "windows" {
-        psCommand := fmt.Sprintf(`Start-Process powershell -WindowStyle Normal -ArgumentList '-Command', 'cd ..; $env:ELEVATOR_ID=\"%s\"; $env:ELEVATOR_PORT=\"%s\"; ./elevator_%s.exe'`, elevatorID, elevatorPort, elevatorID)
+        psCommand := fmt.Sprintf(`Start-Process powershell -WindowStyle Normal -ArgumentList '-Command', 'cd ..; $env:ELEVATOR_ID=\"%s\"; $env:ELEVATOR_PORT=\"%s\"; %s./elevator_%s.exe'`, elevatorID, elevatorPort, powershellVars, elevatorID)
         cmd = exec.Command("powershell", "-Command", psCommand)
     } else if runtime.GOOS == "linux" {
-        bashCommand := fmt.Sprintf(`cd ../.. && ELEVATOR_ID="%s" ELEVATOR_PORT="%s" ./elevator_%s`, elevatorID, elevatorPort, elevatorID)
+        bashCommand := fmt.Sprintf(`cd ../.. && ELEVATOR_ID="%s" ELEVATOR_PORT="%s" %s./elevator_%s`, elevatorID, elevatorPort, bashVars, elevatorID)
         cmd = exec.Command("gnome-terminal", "--", "bash", "-c", bashCommand)
 	}
 	cmd.Stdout = os.Stdout
@@ -81,4 +99,27 @@ func restartElevator(elevatorID string) {
 
 	// Add a longer delay after restarting
     time.Sleep(30 * time.Second) 
-}
\ No newline at end of file
+}
+
+// Passes the configuration file, state directory and key file on as absolute paths, as the
+// elevator is restarted from another directory. Settings given only as flags are
+// not passed on, so put them in the configuration file when using the supervisor.
+func restartVariables(cfg config.Config) map[string]string {
+	variables := make(map[string]string)
+	if cfg.File != "" {
+		if path, err := filepath.Abs(cfg.File); err == nil {
+			variables["ELEVATOR_CONFIG"] = path
+		}
+	}
+	if cfg.StateDir != "" {
+		if path, err := filepath.Abs(cfg.StateDir); err == nil {
+			variables["ELEVATOR_STATE_DIR"] = path
+		}
+	}
+	if cfg.Network.AuthKeyFile != "" {
+		if path, err := filepath.Abs(cfg.Network.AuthKeyFile); err == nil {
+			variables["ELEVATOR_AUTH_KEY_FILE"] = path
+		}
+	}
+	return variables
+}